@@ -1,39 +1,39 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
 	"log"
 	"os"
 	"os/signal"
+	"path"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/SSL0/http-impl/internal/auth"
+	"github.com/SSL0/http-impl/internal/compress"
+	"github.com/SSL0/http-impl/internal/cors"
+	"github.com/SSL0/http-impl/internal/fileserver"
+	"github.com/SSL0/http-impl/internal/proxyproto"
+	"github.com/SSL0/http-impl/internal/ratelimit"
 	"github.com/SSL0/http-impl/internal/request"
 	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/router"
 	"github.com/SSL0/http-impl/internal/server"
+	"github.com/SSL0/http-impl/internal/session"
 )
 
-const port = 42069
-
-const htmlBadRequest = `<html>
-  <head>
-	<title>400 Bad Request</title>
-  </head>
-  <body>
-	<h1>Bad Request</h1>
-	<p>Your request honestly kinda sucked.</p>
-  </body>
-</html>
-`
-
-const htmlInternalServerError = `<html>
-  <head>
-    <title>500 Internal Server Error</title>
-  </head>
-  <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me.</p>
-  </body>
-</html>
-`
+const (
+	port                 = 42069
+	shutdownTimeout      = 30 * time.Second
+	certWatchInterval    = 10 * time.Second
+	jwksWatchInterval    = 10 * time.Second
+	sessionEvictInterval = time.Minute
+	bucketEvictInterval  = time.Minute
+)
 
 const htmlOK = `<html>
   <head>
@@ -46,59 +46,289 @@ const htmlOK = `<html>
 </html>
 `
 
-const htmlNotFound = `<html>
+var htmlError = template.Must(template.New("error").Parse(`<html>
   <head>
-    <title>404 Not Found</title>
+    <title>{{.StatusCode}} {{.StatusText}}</title>
   </head>
   <body>
-    <h1>Not Found</h1>
-    <p>Unknown resource</p>
+    <h1>{{.StatusText}}</h1>
+    <p>{{.Message}}</p>
   </body>
 </html>
-`
+`))
 
-func serverHandle(resWriter *response.Writer, req *request.Request) {
-	switch req.RequestLine.RequestTarget {
-	case "/yourproblem":
-		resWriter.WriteStatusLine(response.StatusBadRequset)
-		h := response.GetDefaultHeaders(len(htmlBadRequest))
-		h.Change("Content-Type", "text/html")
-		resWriter.WriteHeaders(h)
-		resWriter.WriteBody([]byte(htmlBadRequest))
-	case "/myproblem":
-		resWriter.WriteStatusLine(response.StatusInternalServerError)
-		h := response.GetDefaultHeaders(len(htmlInternalServerError))
-		h.Change("Content-Type", "text/html")
-		resWriter.WriteHeaders(h)
-		resWriter.WriteBody([]byte(htmlInternalServerError))
-	case "/correct":
-		resWriter.WriteStatusLine(response.StatusOK)
-		h := response.GetDefaultHeaders(len(htmlOK))
-		h.Change("Content-Type", "text/html")
-		resWriter.WriteHeaders(h)
-		resWriter.WriteBody([]byte(htmlOK))
-	default:
-		resWriter.WriteStatusLine(response.StatusNotFound)
-		h := response.GetDefaultHeaders(len(htmlNotFound))
+var errorRenderer = server.NegotiatedErrorRenderer(
+	server.ErrorFormat{MediaType: server.MediaTypeHTML, Render: server.HTMLErrorRenderer(htmlError)},
+	server.ErrorFormat{MediaType: server.MediaTypeProblem, Render: server.ProblemErrorRenderer},
+	// clients asking for plain JSON get problem details as well
+	server.ErrorFormat{MediaType: server.MediaTypeJSON, Render: server.ProblemErrorRenderer},
+	server.ErrorFormat{MediaType: server.MediaTypeText, Render: server.TextErrorRenderer},
+)
+
+func htmlHandler(statusCode int, body string) server.HandlerFunc {
+	return server.HandleContext(func(ctx context.Context, resWriter response.Writer, req *request.Request) error {
+		h := response.GetDefaultHeaders(len(body))
 		h.Change("Content-Type", "text/html")
-		resWriter.WriteHeaders(h)
-		resWriter.WriteBody([]byte(htmlNotFound))
+
+		if err := resWriter.WriteStatusLine(statusCode); err != nil {
+			return err
+		}
+		if err := resWriter.WriteHeaders(h); err != nil {
+			return err
+		}
+		return resWriter.WriteBody([]byte(body))
+	})
+}
+
+// errorHandler answers with error rendered in the format client accepts
+func errorHandler(statusCode int, message string) server.HandlerFunc {
+	return server.HandleContext(func(ctx context.Context, resWriter response.Writer, req *request.Request) error {
+		return server.NewHandlerError(statusCode, message)
+	})
+}
+
+func newRouter() *router.Router {
+	r := router.New()
+	r.Get("/yourproblem", errorHandler(response.StatusBadRequset, "Your request honestly kinda sucked."))
+	r.Get("/myproblem", errorHandler(response.StatusInternalServerError, "Okay, you know what? This one is on me."))
+	r.Get("/correct", htmlHandler(response.StatusOK, htmlOK))
+	r.NotFound = errorHandler(response.StatusNotFound, "Unknown resource")
+	return r
+}
+
+// whoami answers with name of the authenticated user
+func whoami(w response.Writer, req *request.Request) {
+	p, _ := auth.PrincipalFrom(req)
+	body := []byte(p.Name + "\n")
+
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+var middlewares = server.Chain(
+	server.Logging,
+	compress.Middleware(compress.Options{}),
+)
+
+type certFlags []server.CertFiles
+
+func (c *certFlags) String() string {
+	return fmt.Sprint(*c)
+}
+
+func (c *certFlags) Set(v string) error {
+	certFile, keyFile, ok := strings.Cut(v, ",")
+	if !ok {
+		return fmt.Errorf("expected cert.pem,key.pem: %s", v)
 	}
+	*c = append(*c, server.CertFiles{CertFile: certFile, KeyFile: keyFile})
+	return nil
 }
 
 func main() {
-	server, err := server.ListenAndServe(port, serverHandle)
+	var certs certFlags
+	flag.Var(&certs, "tls", "certificate and key files `cert.pem,key.pem`, can be repeated for SNI")
+	clientCA := flag.String("client-ca", "", "CA certificates `file` to verify client certificates")
+	clientAuth := flag.String("client-auth", "none", "client certificate `mode`: none, optional or required")
+	proxyTrusted := flag.String("proxy-protocol", "", "comma separated `CIDRs` of proxies sending PROXY protocol header")
+	staticDir := flag.String("static-dir", "", "`directory` with static files to serve")
+	staticPrefix := flag.String("static-prefix", "/", "URL `prefix` for static files")
+	spa := flag.Bool("spa", false, "serve index.html of static directory for unknown paths")
+	sessionDir := flag.String("session-dir", "", "`directory` to keep sessions in, they are kept in memory if empty")
+	htpasswd := flag.String("htpasswd", "", "htpasswd `file` with users allowed to access /whoami")
+	jwks := flag.String("jwks", "", "JWKS `file` with keys of access tokens allowed to access /whoami")
+	jwtIssuer := flag.String("jwt-issuer", "", "required `issuer` of access tokens")
+	jwtAudience := flag.String("jwt-audience", "", "required `audience` of access tokens")
+	corsOrigins := flag.String("cors-origins", "", "comma separated `origins` allowed to make cross-origin requests, e.g. https://*.example.com")
+	rateLimit := flag.Int("rate-limit", 0, "allowed `requests` per minute from one client, not limited if 0")
+	rateBurst := flag.Int("rate-burst", 0, "`requests` client can make at once, rate limit by default")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated `CIDRs` of proxies whose Forwarded and X-Forwarded-For are trusted")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := []server.Option{server.WithErrorRenderer(errorRenderer)}
+	var certStore *server.CertStore
+
+	if len(certs) > 0 {
+		var err error
+		certStore, err = server.NewCertStore(certs...)
+		if err != nil {
+			log.Fatalf("failed to load certificates: %v", err)
+		}
+		go certStore.Watch(ctx, certWatchInterval)
+		opts = append(opts, server.WithTLS(certStore.TLSConfig()))
+
+		mode, err := server.ParseClientAuthMode(*clientAuth)
+		if err != nil {
+			log.Fatalf("invalid client auth: %v", err)
+		}
+		if mode != server.ClientAuthNone {
+			pool, err := server.LoadCertPool(*clientCA)
+			if err != nil {
+				log.Fatalf("failed to load client CA: %v", err)
+			}
+			opts = append(opts, server.WithClientAuth(mode, pool))
+		}
+	}
+
+	if *proxyTrusted != "" {
+		trusted, err := proxyproto.ParsePrefixes(*proxyTrusted)
+		if err != nil {
+			log.Fatalf("invalid proxy protocol networks: %v", err)
+		}
+		opts = append(opts, server.WithProxyProtocol(trusted...))
+	}
+
+	r := newRouter()
+
+	if *staticDir != "" {
+		fs, err := fileserver.New(fileserver.Options{
+			Prefix:        *staticPrefix,
+			Root:          *staticDir,
+			SPAFallback:   *spa,
+			Precompressed: true,
+		})
+		if err != nil {
+			log.Fatalf("failed to serve static files: %v", err)
+		}
+		r.Get(path.Join(*staticPrefix, "*path"), fs.Handler())
+	}
+
+	var users *auth.Htpasswd
+	var schemes []auth.Scheme
+
+	if *htpasswd != "" {
+		var err error
+		users, err = auth.LoadHtpasswd(*htpasswd)
+		if err != nil {
+			log.Fatalf("failed to load users: %v", err)
+		}
+		schemes = append(schemes, auth.NewBasic("http-impl", users))
+	}
+
+	if *jwks != "" {
+		keys, err := auth.LoadKeySet(*jwks)
+		if err != nil {
+			log.Fatalf("failed to load JWKS: %v", err)
+		}
+		go keys.Watch(ctx, jwksWatchInterval)
+		schemes = append(schemes, auth.NewBearer(auth.BearerOptions{
+			Realm:    "http-impl",
+			Keys:     keys,
+			Issuer:   *jwtIssuer,
+			Audience: *jwtAudience,
+		}))
+	}
+
+	if len(schemes) > 0 {
+		r.Get("/whoami", auth.Middleware(schemes...)(whoami))
+	}
+
+	var sessionStore session.Store
+
+	// file sessions survive restarts with SIGUSR2
+	if *sessionDir != "" {
+		fileStore, err := session.NewFileStore(*sessionDir)
+		if err != nil {
+			log.Fatalf("failed to open session directory: %v", err)
+		}
+		go fileStore.Evict(ctx, sessionEvictInterval)
+		sessionStore = fileStore
+	} else {
+		memoryStore := session.NewMemoryStore()
+		go memoryStore.Evict(ctx, sessionEvictInterval)
+		sessionStore = memoryStore
+	}
+
+	sessions := session.Middleware(session.Options{
+		Store:    sessionStore,
+		Secure:   len(certs) > 0,
+		SameSite: response.SameSiteLax,
+	})
+
+	handler := sessions(r.Serve)
+
+	if *rateLimit > 0 {
+		trusted, err := proxyproto.ParsePrefixes(*trustedProxies)
+		if err != nil {
+			log.Fatalf("invalid trusted proxy networks: %v", err)
+		}
+
+		limit := ratelimit.Limit{Requests: *rateLimit, Period: time.Minute, Burst: *rateBurst}
+		if err := limit.Validate(); err != nil {
+			log.Fatalf("invalid rate limit: %v", err)
+		}
+
+		buckets := ratelimit.NewStore()
+		go buckets.Evict(ctx, bucketEvictInterval)
+		handler = ratelimit.Middleware(ratelimit.Options{
+			Store: buckets,
+			Limit: limit,
+			Key:   ratelimit.ByIP(trusted...),
+		})(handler)
+	}
+
+	// preflights are answered inside of logging, so they are logged too
+	if *corsOrigins != "" {
+		corsOpts := cors.Options{
+			AllowedOrigins:   strings.Split(*corsOrigins, ","),
+			AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization", session.CSRFHeader},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}
+		if err := corsOpts.Validate(); err != nil {
+			log.Fatalf("invalid cors origins: %v", err)
+		}
+		handler = cors.Middleware(corsOpts)(handler)
+	}
+
+	server, err := server.ListenAndServe(port, middlewares(handler), opts...)
 
 	if err != nil {
 		log.Fatalf("failed to start server: %v", err)
 	}
 
-	defer server.Close()
-
 	log.Printf("server started on port %d\n", port)
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-	log.Printf("found signal to stop server")
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2, syscall.SIGHUP)
+
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			if certStore != nil {
+				if err := certStore.Reload(); err != nil {
+					log.Printf("failed to reload certificates: %v", err)
+				}
+			}
+			if users != nil {
+				if err := users.Reload(); err != nil {
+					log.Printf("failed to reload users: %v", err)
+				}
+			}
+			continue
+		}
+
+		if sig == syscall.SIGUSR2 {
+			proc, err := server.Restart()
+			if err != nil {
+				log.Printf("failed to restart server: %v", err)
+				continue
+			}
+			log.Printf("started new server process %d, draining connections", proc.Pid)
+		} else {
+			log.Printf("found signal to stop server")
+		}
+		break
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer shutdownCancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to gracefully shutdown server: %v", err)
+	}
 }
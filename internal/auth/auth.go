@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+var (
+	ErrMalformed          = errors.New("malformed authorization")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrStaleNonce is returned for valid credentials with expired nonce,
+	// client can retry with a new one without asking user again
+	ErrStaleNonce = errors.New("stale nonce")
+)
+
+// Credentials are parsed from Authorization field, RFC 9110 section 11.4.
+// Depending on the scheme either Token68 or Params is set
+type Credentials struct {
+	Scheme  string
+	Token68 string
+	// Params have lowercased names and unquoted values
+	Params map[string]string
+}
+
+// ParseAuthorization parses credentials of any scheme
+func ParseAuthorization(value string) (Credentials, error) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if !headers.IsToken(scheme) {
+		return Credentials{}, ErrMalformed
+	}
+
+	c := Credentials{Scheme: scheme, Params: map[string]string{}}
+
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return c, nil
+	}
+	if isToken68(rest) {
+		c.Token68 = rest
+		return c, nil
+	}
+
+	for _, param := range headers.SplitList(rest) {
+		name, value, ok := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !ok || !headers.IsToken(name) || value == "" {
+			return Credentials{}, ErrMalformed
+		}
+		// every parameter name can occur only once
+		if _, ok := c.Params[name]; ok {
+			return Credentials{}, ErrMalformed
+		}
+		c.Params[name] = headers.Unquote(value)
+	}
+
+	return c, nil
+}
+
+// isToken68 checks token68 syntax, RFC 9110 section 11.2
+func isToken68(s string) bool {
+	end := len(strings.TrimRight(s, "="))
+	if end == 0 {
+		return false
+	}
+
+	for i := 0; i < end; i++ {
+		c := s[i]
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && !strings.ContainsRune("-._~+/", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// quote always returns quoted-string, auth parameters like realm must not
+// be sent as token, RFC 9110 section 11.5
+func quote(s string) string {
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Principal is the authenticated client
+type Principal struct {
+	Name   string
+	Scheme string
+	// Scopes are granted by access token
+	Scopes []string
+	// Claims are set for JWT access tokens, numbers are json.Number
+	Claims map[string]any
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns principal authenticated by Middleware
+func PrincipalFrom(req *request.Request) (Principal, bool) {
+	p, ok := req.Context().Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// WithPrincipal returns shallow copy of req carrying p
+func WithPrincipal(req *request.Request, p Principal) *request.Request {
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p))
+}
+
+// Scheme authenticates credentials of one authentication scheme
+type Scheme interface {
+	// Name is compared with scheme of credentials case insensitively
+	Name() string
+	Authenticate(req *request.Request, c Credentials) (Principal, error)
+	// Challenge returns WWW-Authenticate challenge, err is the reason of
+	// failed authentication or nil if there were no credentials
+	Challenge(err error) string
+}
+
+// Middleware authenticates requests with one of the schemes. Requests
+// without valid credentials are answered with 401 and challenges of all
+// schemes
+func Middleware(schemes ...Scheme) server.Middleware {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			value, ok := req.Headers.GetString("Authorization")
+			if !ok {
+				writeUnauthorized(w, req, schemes, nil, nil)
+				return
+			}
+
+			c, err := ParseAuthorization(value)
+			if err != nil {
+				writeUnauthorized(w, req, schemes, nil, err)
+				return
+			}
+
+			for _, s := range schemes {
+				if !strings.EqualFold(s.Name(), c.Scheme) {
+					continue
+				}
+
+				p, err := s.Authenticate(req, c)
+				if err != nil {
+					writeUnauthorized(w, req, schemes, s, err)
+					return
+				}
+
+				next(w, WithPrincipal(req, p))
+				return
+			}
+
+			writeUnauthorized(w, req, schemes, nil, nil)
+		}
+	}
+}
+
+// writeUnauthorized answers with challenge of every scheme, failed scheme
+// gets err so it can tell the reason
+func writeUnauthorized(w response.Writer, req *request.Request, schemes []Scheme, failed Scheme, err error) {
+	challenges := []string{}
+	for _, s := range schemes {
+		if s == failed {
+			challenges = append(challenges, s.Challenge(err))
+		} else {
+			challenges = append(challenges, s.Challenge(nil))
+		}
+	}
+
+	writeChallenges(w, req, response.StatusUnauthorized, challenges...)
+}
+
+func writeChallenges(w response.Writer, req *request.Request, statusCode int, challenges ...string) {
+	ow := response.Observe(w)
+	ow.OnHeaders(func(_ int, h headers.Headers) {
+		for _, challenge := range challenges {
+			h.Set("WWW-Authenticate", challenge)
+		}
+	})
+	server.RenderError(ow, req, server.NewHandlerError(statusCode, ""))
+}
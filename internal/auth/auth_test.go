@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func serve(t *testing.T, h server.HandlerFunc, target string, extra ...string) string {
+	t.Helper()
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func whoami(w response.Writer, req *request.Request) {
+	p, _ := PrincipalFrom(req)
+	body := []byte(p.Scheme + " " + p.Name)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "users")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestParseAuthorization(t *testing.T) {
+	t.Run("ok, token68", func(t *testing.T) {
+		c, err := ParseAuthorization("Basic dXNlcjpwYXNz")
+		require.NoError(t, err)
+		assert.Equal(t, "Basic", c.Scheme)
+		assert.Equal(t, "dXNlcjpwYXNz", c.Token68)
+	})
+
+	t.Run("ok, token68 with padding", func(t *testing.T) {
+		c, err := ParseAuthorization("Basic dXNlcjpw==")
+		require.NoError(t, err)
+		assert.Equal(t, "dXNlcjpw==", c.Token68)
+	})
+
+	t.Run("ok, auth params", func(t *testing.T) {
+		c, err := ParseAuthorization(`Digest Username="admin", realm="a, \"b\"", nc=00000001`)
+		require.NoError(t, err)
+		assert.Equal(t, "Digest", c.Scheme)
+		assert.Equal(t, map[string]string{"username": "admin", "realm": `a, "b"`, "nc": "00000001"}, c.Params)
+	})
+
+	t.Run("ok, scheme only", func(t *testing.T) {
+		c, err := ParseAuthorization("Negotiate")
+		require.NoError(t, err)
+		assert.Equal(t, "Negotiate", c.Scheme)
+		assert.Empty(t, c.Token68)
+	})
+
+	t.Run("fail, malformed", func(t *testing.T) {
+		for _, value := range []string{"", "Bad/Scheme x", "Digest a b", `Digest a="x", b`, "Digest a=1, a=2"} {
+			_, err := ParseAuthorization(value)
+			assert.ErrorIs(t, err, ErrMalformed, value)
+		}
+	})
+}
+
+func TestBasic(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	shaSum := sha1.Sum([]byte("p:ss"))
+
+	users, err := LoadHtpasswd(writeFile(t, "# users\n"+
+		"admin:"+strings.Replace(string(bcryptHash), "$2a$", "$2y$", 1)+"\n"+
+		"\n"+
+		"legacy:{SHA}"+base64.StdEncoding.EncodeToString(shaSum[:])+"\n"))
+	require.NoError(t, err)
+
+	h := Middleware(NewBasic(`Admin "area"`, users))(whoami)
+	basic := func(user, password string) string {
+		return "Authorization: basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	t.Run("ok, bcrypt", func(t *testing.T) {
+		resp := serve(t, h, "/", basic("admin", "secret"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK")
+		assert.True(t, strings.HasSuffix(resp, "Basic admin"))
+	})
+
+	t.Run("ok, sha with colon in password", func(t *testing.T) {
+		resp := serve(t, h, "/", basic("legacy", "p:ss"))
+		assert.True(t, strings.HasSuffix(resp, "Basic legacy"))
+	})
+
+	t.Run("fail, no credentials", func(t *testing.T) {
+		resp := serve(t, h, "/")
+		assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized")
+		assert.Contains(t, resp, `www-authenticate: Basic realm="Admin \"area\"", charset="UTF-8"`)
+	})
+
+	t.Run("fail, wrong password", func(t *testing.T) {
+		assert.Contains(t, serve(t, h, "/", basic("admin", "nope")), "HTTP/1.1 401 Unauthorized")
+		assert.Contains(t, serve(t, h, "/", basic("nobody", "secret")), "HTTP/1.1 401 Unauthorized")
+	})
+
+	t.Run("ok, unknown user is compared with hash of the same cost", func(t *testing.T) {
+		cost, err := bcrypt.Cost([]byte(users.dummy))
+		require.NoError(t, err)
+		assert.Equal(t, bcrypt.MinCost, cost)
+
+		shaOnly, err := LoadHtpasswd(writeFile(t, "legacy:{SHA}"+base64.StdEncoding.EncodeToString(shaSum[:])+"\n"))
+		require.NoError(t, err)
+		assert.Equal(t, dummyHash, shaOnly.dummy)
+		assert.False(t, shaOnly.Verify("nobody", "p:ss"))
+	})
+
+	t.Run("fail, other scheme", func(t *testing.T) {
+		assert.Contains(t, serve(t, h, "/", "Authorization: Bearer abc"), "HTTP/1.1 401 Unauthorized")
+	})
+
+	t.Run("fail, unsupported hash", func(t *testing.T) {
+		_, err := LoadHtpasswd(writeFile(t, "admin:$apr1$salt$hash\n"))
+		assert.ErrorContains(t, err, "unsupported password hash of user admin")
+
+		_, err = LoadHtpasswd(writeFile(t, "admin\n"))
+		assert.ErrorContains(t, err, ":1: malformed entry")
+	})
+}
+
+// digestChallenge returns nonce and whole challenge from 401 response
+func digestChallenge(t *testing.T, resp string) (string, string) {
+	t.Helper()
+	for _, line := range strings.Split(resp, "\r\n") {
+		if challenge, ok := strings.CutPrefix(line, "www-authenticate: "); ok {
+			c, err := ParseAuthorization(challenge)
+			require.NoError(t, err)
+			return c.Params["nonce"], challenge
+		}
+	}
+	t.Fatal("no challenge in response")
+	return "", ""
+}
+
+func digestAuthorization(user, password, realm, nonce, nc, method, uri string) string {
+	ha1 := DigestHA1(user, realm, password)
+	ha2 := digestHash(method + ":" + uri)
+	resp := digestHash(ha1 + ":" + nonce + ":" + nc + ":cnonce:auth:" + ha2)
+	return `Authorization: Digest username="` + user + `", realm="` + realm + `", uri="` + uri +
+		`", algorithm=SHA-256, qop=auth, nc=` + nc + `, cnonce="cnonce", nonce="` + nonce +
+		`", response="` + resp + `"`
+}
+
+func TestDigest(t *testing.T) {
+	users, err := LoadHtdigest(writeFile(t, "admin:api:"+DigestHA1("admin", "api", "secret")+"\n"))
+	require.NoError(t, err)
+
+	d := NewDigest(DigestOptions{Realm: "api", Users: users, NonceTTL: time.Minute})
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	h := Middleware(d)(whoami)
+
+	nonce, challenge := digestChallenge(t, serve(t, h, "/data?x=1"))
+	assert.Contains(t, challenge, `Digest realm="api", qop="auth", algorithm=SHA-256, nonce=`)
+	assert.NotContains(t, challenge, "stale")
+
+	t.Run("ok, authenticated", func(t *testing.T) {
+		resp := serve(t, h, "/data?x=1", digestAuthorization("admin", "secret", "api", nonce, "00000001", "GET", "/data?x=1"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK")
+		assert.True(t, strings.HasSuffix(resp, "Digest admin"))
+	})
+
+	t.Run("ok, nonce reused with greater count", func(t *testing.T) {
+		resp := serve(t, h, "/", digestAuthorization("admin", "secret", "api", nonce, "00000002", "GET", "/"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK")
+	})
+
+	t.Run("fail, replayed count", func(t *testing.T) {
+		resp := serve(t, h, "/", digestAuthorization("admin", "secret", "api", nonce, "00000002", "GET", "/"))
+		assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized")
+	})
+
+	t.Run("fail, wrong password", func(t *testing.T) {
+		resp := serve(t, h, "/", digestAuthorization("admin", "nope", "api", nonce, "00000003", "GET", "/"))
+		assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized")
+	})
+
+	t.Run("fail, other uri", func(t *testing.T) {
+		resp := serve(t, h, "/admin", digestAuthorization("admin", "secret", "api", nonce, "00000004", "GET", "/"))
+		assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized")
+	})
+
+	t.Run("fail, forged nonce", func(t *testing.T) {
+		forged := NewDigest(DigestOptions{Realm: "api", Users: users}).newNonce()
+		resp := serve(t, h, "/", digestAuthorization("admin", "secret", "api", forged, "00000001", "GET", "/"))
+		assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized")
+	})
+
+	t.Run("fail, expired nonce is stale", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		resp := serve(t, h, "/", digestAuthorization("admin", "secret", "api", nonce, "00000005", "GET", "/"))
+		assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized")
+
+		newNonce, challenge := digestChallenge(t, resp)
+		assert.Contains(t, challenge, ", stale=true")
+		assert.NotEqual(t, nonce, newNonce)
+
+		d.evictExpired()
+		assert.Empty(t, d.counts)
+	})
+
+	t.Run("fail, HA1 is not SHA-256", func(t *testing.T) {
+		_, err := LoadHtdigest(writeFile(t, "admin:api:0123abcd\n"))
+		assert.ErrorContains(t, err, "HA1 of user admin is not SHA-256")
+	})
+}
+
+func TestMiddlewareSchemes(t *testing.T) {
+	users, err := LoadHtdigest(writeFile(t, "admin:api:"+DigestHA1("admin", "api", "secret")+"\n"))
+	require.NoError(t, err)
+
+	h := Middleware(
+		NewBasic("api", PasswordVerifierFunc(func(user, password string) bool { return false })),
+		NewDigest(DigestOptions{Realm: "api", Users: users}),
+	)(whoami)
+
+	t.Run("ok, challenge of every scheme", func(t *testing.T) {
+		resp := serve(t, h, "/")
+		assert.Contains(t, resp, `www-authenticate: Basic realm="api", charset="UTF-8", Digest realm="api"`)
+	})
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/request"
+)
+
+// PasswordVerifier checks user password, e.g. Htpasswd
+type PasswordVerifier interface {
+	Verify(user, password string) bool
+}
+
+// PasswordVerifierFunc adapts function to PasswordVerifier
+type PasswordVerifierFunc func(user, password string) bool
+
+func (f PasswordVerifierFunc) Verify(user, password string) bool {
+	return f(user, password)
+}
+
+// Basic is Basic authentication scheme, RFC 7617. Password is sent in
+// clear text, so it must be used only over TLS
+type Basic struct {
+	realm string
+	users PasswordVerifier
+}
+
+func NewBasic(realm string, users PasswordVerifier) *Basic {
+	return &Basic{realm: realm, users: users}
+}
+
+func (b *Basic) Name() string {
+	return "Basic"
+}
+
+func (b *Basic) Authenticate(_ *request.Request, c Credentials) (Principal, error) {
+	decoded, err := base64.StdEncoding.DecodeString(c.Token68)
+	if err != nil {
+		return Principal{}, ErrMalformed
+	}
+
+	// user-id can't contain colon, password can
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return Principal{}, ErrMalformed
+	}
+
+	if !b.users.Verify(user, password) {
+		return Principal{}, ErrInvalidCredentials
+	}
+	return Principal{Name: user, Scheme: b.Name()}, nil
+}
+
+// Challenge asks for UTF-8 credentials, RFC 7617 section 2.1
+func (b *Basic) Challenge(_ error) string {
+	return "Basic realm=" + quote(b.realm) + `, charset="UTF-8"`
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const defaultClockSkew = time.Minute
+
+// ErrInvalidToken is wrapped by all errors of token verification, their
+// message is sent in error_description
+var ErrInvalidToken = errors.New("invalid token")
+
+type BearerOptions struct {
+	Realm string
+	Keys  *KeySet
+	// Issuer and Audience are checked if they are not empty
+	Issuer   string
+	Audience string
+	// ClockSkew tolerates clock difference with token issuer, 1 minute by
+	// default
+	ClockSkew time.Duration
+}
+
+// Bearer is Bearer authentication scheme, RFC 6750, with JWT access
+// tokens signed with HS256, RS256 or ES256
+type Bearer struct {
+	opts BearerOptions
+	now  func() time.Time
+}
+
+func NewBearer(opts BearerOptions) *Bearer {
+	if opts.ClockSkew <= 0 {
+		opts.ClockSkew = defaultClockSkew
+	}
+	return &Bearer{opts: opts, now: time.Now}
+}
+
+func (b *Bearer) Name() string {
+	return "Bearer"
+}
+
+// Authenticate returns principal with sub claim as name and scopes from
+// scope or scp claim
+func (b *Bearer) Authenticate(_ *request.Request, c Credentials) (Principal, error) {
+	if c.Token68 == "" {
+		return Principal{}, ErrMalformed
+	}
+
+	claims, err := b.Verify(c.Token68)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return Principal{Name: sub, Scheme: b.Name(), Scopes: scopesOf(claims), Claims: claims}, nil
+}
+
+// Challenge adds error attributes of RFC 6750 section 3.1, there are none
+// when client sent no token
+func (b *Bearer) Challenge(err error) string {
+	challenge := "Bearer realm=" + quote(b.opts.Realm)
+
+	switch {
+	case errors.Is(err, ErrMalformed):
+		challenge += `, error="invalid_request"`
+	case errors.Is(err, ErrInvalidToken):
+		challenge += `, error="invalid_token", error_description=` + quote(err.Error())
+	}
+	return challenge
+}
+
+// RequireScopes answers 403 with insufficient_scope error unless token
+// grants all scopes. It has to run after Middleware
+func (b *Bearer) RequireScopes(scopes ...string) server.Middleware {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			p, ok := PrincipalFrom(req)
+			if !ok {
+				writeChallenges(w, req, response.StatusUnauthorized, b.Challenge(nil))
+				return
+			}
+
+			for _, scope := range scopes {
+				if !p.HasScope(scope) {
+					writeChallenges(w, req, response.StatusForbidden, b.Challenge(nil)+
+						`, error="insufficient_scope", scope=`+quote(strings.Join(scopes, " ")))
+					return
+				}
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+// Verify checks signature and claims of JWT in compact serialization,
+// RFC 7519, and returns its claims
+func (b *Bearer) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJSONSegment(parts[0], &header); err != nil {
+		return nil, invalidToken("malformed header")
+	}
+	// no extensions are supported, so tokens requiring them are rejected
+	if len(header.Crit) > 0 {
+		return nil, invalidToken("unsupported critical header")
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, invalidToken("malformed signature")
+	}
+
+	keys := b.opts.Keys.find(header.Kid, header.Alg)
+	if len(keys) == 0 {
+		return nil, invalidToken("unknown key or algorithm")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !slices.ContainsFunc(keys, func(key any) bool {
+		return verifySignature(header.Alg, key, signed, signature)
+	}) {
+		return nil, invalidToken("invalid signature")
+	}
+
+	claims := map[string]any{}
+	if err := decodeJSONSegment(parts[1], &claims); err != nil {
+		return nil, invalidToken("malformed claims")
+	}
+	if err := b.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (b *Bearer) checkClaims(claims map[string]any) error {
+	now := b.now()
+	skew := b.opts.ClockSkew
+
+	exp, ok := numericDate(claims, "exp")
+	if !ok {
+		return invalidToken("token has no expiration time")
+	}
+	if !now.Before(exp.Add(skew)) {
+		return invalidToken("token is expired")
+	}
+
+	if _, ok := claims["nbf"]; ok {
+		nbf, ok := numericDate(claims, "nbf")
+		if !ok || now.Add(skew).Before(nbf) {
+			return invalidToken("token is not valid yet")
+		}
+	}
+
+	if b.opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != b.opts.Issuer {
+			return invalidToken("unexpected issuer")
+		}
+	}
+
+	if b.opts.Audience != "" && !slices.Contains(stringsClaim(claims, "aud"), b.opts.Audience) {
+		return invalidToken("unexpected audience")
+	}
+
+	return nil
+}
+
+func verifySignature(alg string, key any, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch alg {
+	case algHS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case algRS256:
+		return rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case algES256:
+		// signature is R and S of fixed size, not ASN.1, RFC 7518
+		// section 3.4
+		if len(signature) != 2*p256CoordSize {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:p256CoordSize])
+		s := new(big.Int).SetBytes(signature[p256CoordSize:])
+		return ecdsa.Verify(key.(*ecdsa.PublicKey), digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+func invalidToken(description string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidToken, description)
+}
+
+func decodeJSONSegment(segment string, v any) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// numericDate returns claim in seconds since epoch, fractions are allowed
+func numericDate(claims map[string]any, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+}
+
+// stringsClaim returns claim that is either a string or an array of them
+func stringsClaim(claims map[string]any, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// scopesOf returns space separated scope claim, RFC 9068, or scp claim
+// used by some issuers
+func scopesOf(claims map[string]any) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+
+	scopes := []string{}
+	for _, s := range stringsClaim(claims, "scp") {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return scopes
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return testKeys{secret: []byte(strings.Repeat("s", 32)), rsa: rsaKey, ec: ecKey}
+}
+
+func (k testKeys) jwks() string {
+	keys := []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64.EncodeToString(k.secret)},
+		{"kty": "RSA", "kid": "rs", "use": "sig", "n": b64.EncodeToString(k.rsa.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64.EncodeToString(k.ec.X.FillBytes(make([]byte, 32))),
+			"y": b64.EncodeToString(k.ec.Y.FillBytes(make([]byte, 32)))},
+		// ignored keys
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": "AA"},
+	}
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	return string(data)
+}
+
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+// tamper replaces claims of token keeping the signature
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = b64.EncodeToString([]byte(`{"sub":"mallory"}`))
+	return strings.Join(parts, ".")
+}
+
+func TestBearer(t *testing.T) {
+	keys := newTestKeys(t)
+	keySet, err := LoadKeySet(writeFile(t, keys.jwks()))
+	require.NoError(t, err)
+
+	now := time.Now()
+	bearer := NewBearer(BearerOptions{Realm: "api", Keys: keySet, Issuer: "https://issuer", Audience: "api"})
+	bearer.now = func() time.Time { return now }
+
+	h := Middleware(bearer)(bearer.RequireScopes("read")(whoami))
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "https://issuer", "aud": []string{"web", "api"},
+			"exp": now.Add(time.Hour).Unix(), "scope": "read write"}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	bearerLine := func(token string) string {
+		return "Authorization: Bearer " + token
+	}
+
+	t.Run("ok, every algorithm", func(t *testing.T) {
+		for alg, kid := range map[string]string{"HS256": "hs", "RS256": "rs", "ES256": "es"} {
+			resp := serve(t, h, "/", bearerLine(keys.sign(t, alg, kid, claims(nil))))
+			assert.Contains(t, resp, "HTTP/1.1 200 OK", alg)
+			assert.True(t, strings.HasSuffix(resp, "Bearer alice"), alg)
+		}
+	})
+
+	t.Run("ok, without kid and scp claim", func(t *testing.T) {
+		token := keys.sign(t, "ES256", "", claims(map[string]any{"scope": nil, "scp": []string{"read"}}))
+		assert.Contains(t, serve(t, h, "/", bearerLine(token)), "HTTP/1.1 200 OK")
+	})
+
+	t.Run("ok, expired within clock skew", func(t *testing.T) {
+		token := keys.sign(t, "HS256", "hs", claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix(), "nbf": now.Add(30 * time.Second).Unix()}))
+		assert.Contains(t, serve(t, h, "/", bearerLine(token)), "HTTP/1.1 200 OK")
+	})
+
+	t.Run("fail, no token", func(t *testing.T) {
+		resp := serve(t, h, "/")
+		assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized")
+		assert.Contains(t, resp, "www-authenticate: Bearer realm=\"api\"\r\n")
+	})
+
+	t.Run("fail, invalid tokens", func(t *testing.T) {
+		tests := map[string]string{
+			"token is expired":          keys.sign(t, "HS256", "hs", claims(map[string]any{"exp": now.Add(-2 * time.Minute).Unix()})),
+			"token is not valid yet":    keys.sign(t, "HS256", "hs", claims(map[string]any{"nbf": now.Add(2 * time.Minute).Unix()})),
+			"token has no expiration":   keys.sign(t, "HS256", "hs", claims(map[string]any{"exp": nil})),
+			"unexpected issuer":         keys.sign(t, "HS256", "hs", claims(map[string]any{"iss": "https://other"})),
+			"unexpected audience":       keys.sign(t, "HS256", "hs", claims(map[string]any{"aud": "web"})),
+			"unknown key or algorithm":  keys.sign(t, "HS256", "rs", claims(nil)),
+			"invalid signature":         tamper(keys.sign(t, "RS256", "rs", claims(nil))),
+			"malformed token":           "abc.def",
+			"unsupported critical head": b64.EncodeToString([]byte(`{"alg":"HS256","crit":["b64"]}`)) + ".e30.AA",
+		}
+		for description, token := range tests {
+			resp := serve(t, h, "/", bearerLine(token))
+			assert.Contains(t, resp, "HTTP/1.1 401 Unauthorized", description)
+			assert.Contains(t, resp, `www-authenticate: Bearer realm="api", error="invalid_token", error_description="invalid token: `+description, description)
+		}
+	})
+
+	t.Run("fail, algorithm none", func(t *testing.T) {
+		token := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"alice"}`)) + "."
+		assert.Contains(t, serve(t, h, "/", bearerLine(token)), `error="invalid_token"`)
+	})
+
+	t.Run("fail, insufficient scope", func(t *testing.T) {
+		token := keys.sign(t, "HS256", "hs", claims(map[string]any{"scope": "write"}))
+		resp := serve(t, h, "/", bearerLine(token))
+		assert.Contains(t, resp, "HTTP/1.1 403 Forbidden")
+		assert.Contains(t, resp, `www-authenticate: Bearer realm="api", error="insufficient_scope", scope="read"`)
+	})
+}
+
+func TestKeySet(t *testing.T) {
+	keys := newTestKeys(t)
+	path := writeFile(t, `{"keys":[]}`)
+
+	keySet, err := LoadKeySet(path)
+	require.NoError(t, err)
+	assert.Empty(t, keySet.find("", "HS256"))
+
+	t.Run("ok, reloaded on change", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(keys.jwks()), 0o600))
+		require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+
+		assert.True(t, keySet.changed())
+		require.NoError(t, keySet.Reload())
+		assert.False(t, keySet.changed())
+
+		assert.Len(t, keySet.find("", "HS256"), 1)
+		assert.Len(t, keySet.find("rs", "RS256"), 1)
+		assert.Empty(t, keySet.find("es", "RS256"))
+		assert.Empty(t, keySet.find("enc", "RS256"))
+	})
+
+	t.Run("fail, invalid keys are kept", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`{"keys":[{"kty":"oct","k":"c2hvcnQ"}]}`), 0o600))
+		assert.ErrorContains(t, keySet.Reload(), "symmetric key must have at least 32 bytes")
+		assert.Len(t, keySet.find("", "ES256"), 1)
+	})
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+)
+
+const (
+	defaultNonceTTL = 5 * time.Minute
+	digestAlgorithm = "SHA-256"
+	digestQOP       = "auth"
+	nonceKeySize    = 32
+	nonceRandSize   = 16
+	ncSize          = 8
+)
+
+// DigestUsers returns HA1 of user in realm, see DigestHA1
+type DigestUsers interface {
+	HA1(user, realm string) (string, bool)
+}
+
+// DigestHA1 returns hex SHA-256 of "user:realm:password", so the password
+// itself doesn't have to be stored
+func DigestHA1(user, realm, password string) string {
+	return digestHash(user + ":" + realm + ":" + password)
+}
+
+type DigestOptions struct {
+	Realm string
+	Users DigestUsers
+	// NonceTTL is 5 minutes by default. Expired nonce is answered with
+	// stale=true, so client retries with a new one
+	NonceTTL time.Duration
+}
+
+// Digest is Digest authentication scheme with SHA-256 and qop=auth, RFC
+// 7616. Nonces are signed by random key, so they become invalid on restart
+type Digest struct {
+	opts DigestOptions
+	key  []byte
+	now  func() time.Time
+
+	mu sync.Mutex
+	// counts has the last nonce count of every used nonce to reject
+	// replayed requests
+	counts map[string]nonceCount
+}
+
+type nonceCount struct {
+	nc      uint64
+	expires time.Time
+}
+
+func NewDigest(opts DigestOptions) *Digest {
+	if opts.NonceTTL <= 0 {
+		opts.NonceTTL = defaultNonceTTL
+	}
+
+	key := make([]byte, nonceKeySize)
+	rand.Read(key)
+
+	return &Digest{opts: opts, key: key, now: time.Now, counts: map[string]nonceCount{}}
+}
+
+func (d *Digest) Name() string {
+	return "Digest"
+}
+
+func (d *Digest) Authenticate(req *request.Request, c Credentials) (Principal, error) {
+	p := c.Params
+	user, nonce, uri := p["username"], p["nonce"], p["uri"]
+
+	// userhash is not offered in challenge, so clients don't use it
+	if user == "" || strings.EqualFold(p["userhash"], "true") {
+		return Principal{}, ErrInvalidCredentials
+	}
+	if p["realm"] != d.opts.Realm || !strings.EqualFold(p["algorithm"], digestAlgorithm) ||
+		p["qop"] != digestQOP || p["cnonce"] == "" {
+		return Principal{}, ErrInvalidCredentials
+	}
+	// uri is compared with request target, so credentials can't be used
+	// for other resource
+	if uri != req.RequestLine.RequestTarget {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	nc, err := strconv.ParseUint(p["nc"], 16, 32)
+	if err != nil || len(p["nc"]) != ncSize {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	issued, ok := d.nonceTime(nonce)
+	if !ok {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	ha1, ok := d.opts.Users.HA1(user, d.opts.Realm)
+	if !ok {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	ha2 := digestHash(req.RequestLine.Method + ":" + uri)
+	expected := digestHash(strings.Join([]string{ha1, nonce, p["nc"], p["cnonce"], digestQOP, ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(p["response"]))) != 1 {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	expires := issued.Add(d.opts.NonceTTL)
+	if !d.now().Before(expires) {
+		return Principal{}, ErrStaleNonce
+	}
+	if !d.useCount(nonce, nc, expires) {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	return Principal{Name: user, Scheme: d.Name()}, nil
+}
+
+// Challenge sends a new nonce, stale=true tells client its credentials
+// are valid but nonce is expired
+func (d *Digest) Challenge(err error) string {
+	challenge := fmt.Sprintf(`Digest realm=%s, qop="%s", algorithm=%s, nonce="%s"`,
+		quote(d.opts.Realm), digestQOP, digestAlgorithm, d.newNonce())
+	if errors.Is(err, ErrStaleNonce) {
+		challenge += ", stale=true"
+	}
+	return challenge
+}
+
+// Evict forgets counts of expired nonces every interval until ctx is done
+func (d *Digest) Evict(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.evictExpired()
+		}
+	}
+}
+
+func (d *Digest) evictExpired() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	maps.DeleteFunc(d.counts, func(_ string, c nonceCount) bool {
+		return !now.Before(c.expires)
+	})
+}
+
+// useCount accepts nonce count only if it is greater than the previous one
+// of the same nonce
+func (d *Digest) useCount(nonce string, nc uint64, expires time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if prev, ok := d.counts[nonce]; ok && nc <= prev.nc {
+		return false
+	}
+	d.counts[nonce] = nonceCount{nc: nc, expires: expires}
+	return true
+}
+
+// newNonce returns issue time and random bytes signed with HMAC, so the
+// server doesn't keep nonces until they are used
+func (d *Digest) newNonce() string {
+	b := binary.BigEndian.AppendUint64(nil, uint64(d.now().UnixNano()))
+	b = append(b, make([]byte, nonceRandSize)...)
+	rand.Read(b[len(b)-nonceRandSize:])
+	return base64.RawURLEncoding.EncodeToString(append(b, d.nonceMAC(b)...))
+}
+
+// nonceTime returns issue time of nonce created by newNonce
+func (d *Digest) nonceTime(nonce string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	payloadSize := 8 + nonceRandSize
+	if err != nil || len(b) != payloadSize+sha256.Size {
+		return time.Time{}, false
+	}
+
+	if !hmac.Equal(b[payloadSize:], d.nonceMAC(b[:payloadSize])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), true
+}
+
+func (d *Digest) nonceMAC(payload []byte) []byte {
+	h := hmac.New(sha256.New, d.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func digestHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Htdigest returns HA1 from file with "user:realm:HA1" lines. Note that
+// htdigest tool writes MD5 hashes, entries have to be made with DigestHA1
+// or e.g. printf 'user:realm:password' | sha256sum
+type Htdigest struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+func LoadHtdigest(path string) (*Htdigest, error) {
+	h := &Htdigest{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload reads the file again, users are kept if it is invalid
+func (h *Htdigest) Reload() error {
+	entries, err := readEntries(h.path, 3)
+	if err != nil {
+		return err
+	}
+
+	users := map[string]string{}
+	for _, e := range entries {
+		if _, err := hex.DecodeString(e[2]); err != nil || len(e[2]) != hex.EncodedLen(sha256.Size) {
+			return fmt.Errorf("%s: HA1 of user %s is not SHA-256", h.path, e[0])
+		}
+		users[e[0]+":"+e[1]] = strings.ToLower(e[2])
+	}
+
+	h.mu.Lock()
+	h.users = users
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Htdigest) HA1(user, realm string) (string, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ha1, ok := h.users[user+":"+realm]
+	return ha1, ok
+}
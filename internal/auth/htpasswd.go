@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const shaPrefix = "{SHA}"
+
+// dummyHash is compared with password of unknown user, so it takes as long
+// to reject as a known one and user names can't be found by timing
+const dummyHash = "$2a$10$9IksfJBMMn/eDwvVu9nmqOaM31cbwdkRAN.86Sb0UrTQ.WY5GOe32"
+
+// Htpasswd verifies passwords against htpasswd file, one "user:hash" per
+// line. Supported hashes are bcrypt ($2a$, $2b$, $2y$) and {SHA}
+type Htpasswd struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]string
+	// dummy is like dummyHash, with the highest bcrypt cost of users
+	dummy string
+}
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload reads the file again, users are kept if it is invalid
+func (h *Htpasswd) Reload() error {
+	entries, err := readEntries(h.path, 2)
+	if err != nil {
+		return err
+	}
+
+	users := map[string]string{}
+	cost := 0
+	for _, e := range entries {
+		if !supportedHash(e[1]) {
+			return fmt.Errorf("%s: unsupported password hash of user %s", h.path, e[0])
+		}
+		users[e[0]] = e[1]
+
+		if c, err := bcrypt.Cost([]byte(e[1])); err == nil && c > cost {
+			cost = c
+		}
+	}
+
+	dummy := dummyHash
+	if cost != 0 && cost != bcrypt.DefaultCost {
+		hash, err := bcrypt.GenerateFromPassword([]byte("unknown user"), cost)
+		if err != nil {
+			return err
+		}
+		dummy = string(hash)
+	}
+
+	h.mu.Lock()
+	h.users = users
+	h.dummy = dummy
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Htpasswd) Verify(user, password string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[user]
+	dummy := h.dummy
+	h.mu.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword([]byte(dummy), []byte(password))
+		return false
+	}
+
+	if sum, ok := strings.CutPrefix(hash, shaPrefix); ok {
+		expected := sha1.Sum([]byte(password))
+		actual, err := base64.StdEncoding.DecodeString(sum)
+		return err == nil && subtle.ConstantTimeCompare(actual, expected[:]) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func supportedHash(hash string) bool {
+	for _, prefix := range []string{shaPrefix, "$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readEntries reads lines of n colon separated fields, the first one is
+// user. Empty lines and comments are skipped
+func readEntries(path string, n int) ([][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := [][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, ":", n)
+		if len(fields) != n || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d: malformed entry", path, line)
+		}
+		entries = append(entries, fields)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+	algES256 = "ES256"
+
+	minRSAKeyBits  = 2048
+	minHMACKeySize = 32
+	p256CoordSize  = 32
+)
+
+// jwk is a verification key with algorithm it can be used with. Key is
+// []byte for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey for
+// ES256
+type jwk struct {
+	kid string
+	alg string
+	key any
+}
+
+// KeySet holds keys of JWKS file, RFC 7517. Keys of unsupported types
+// and keys not intended for signatures are ignored
+type KeySet struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    []jwk
+	modTime time.Time
+}
+
+func LoadKeySet(path string) (*KeySet, error) {
+	k := &KeySet{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads the file again. On error the previously loaded keys are
+// kept
+func (k *KeySet) Reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", k.path, err)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.modTime = info.ModTime()
+	k.mu.Unlock()
+
+	slog.Info("JWKS loaded", "count", len(keys))
+	return nil
+}
+
+func (k *KeySet) changed() bool {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return false
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return !info.ModTime().Equal(k.modTime)
+}
+
+// Watch reloads keys when the file is modified, it blocks until ctx is
+// done
+func (k *KeySet) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !k.changed() {
+				continue
+			}
+			if err := k.Reload(); err != nil {
+				slog.Error("failed to reload JWKS", "context_error", err)
+			}
+		}
+	}
+}
+
+// find returns keys usable with alg, only the key with kid if it is set
+func (k *KeySet) find(kid, alg string) []any {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := []any{}
+	for _, key := range k.keys {
+		if key.alg == alg && (kid == "" || key.kid == kid) {
+			keys = append(keys, key.key)
+		}
+	}
+	return keys
+}
+
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// oct
+	K string `json:"k"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := []jwk{}
+	for i, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJWK(raw)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		// alg of the key restricts it to one algorithm, e.g. RSA key for
+		// PS256 isn't used for RS256
+		if key.key == nil || raw.Alg != "" && raw.Alg != key.alg {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// parseJWK returns jwk with nil key for unsupported key types
+func parseJWK(raw jwkJSON) (jwk, error) {
+	key := jwk{kid: raw.Kid}
+
+	switch raw.Kty {
+	case "oct":
+		secret, err := decodeSegment(raw.K)
+		if err != nil || len(secret) < minHMACKeySize {
+			return jwk{}, fmt.Errorf("symmetric key must have at least %d bytes", minHMACKeySize)
+		}
+		key.alg, key.key = algHS256, secret
+
+	case "RSA":
+		n, errN := decodeSegment(raw.N)
+		e, errE := decodeSegment(raw.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return jwk{}, fmt.Errorf("invalid RSA key")
+		}
+
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return jwk{}, fmt.Errorf("RSA key must have at least %d bits", minRSAKeyBits)
+		}
+		key.alg, key.key = algRS256, pub
+
+	case "EC":
+		if raw.Crv != "P-256" {
+			return key, nil
+		}
+
+		x, errX := decodeSegment(raw.X)
+		y, errY := decodeSegment(raw.Y)
+		if errX != nil || errY != nil || len(x) != p256CoordSize || len(y) != p256CoordSize {
+			return jwk{}, fmt.Errorf("invalid EC key")
+		}
+
+		// ecdh checks the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return jwk{}, fmt.Errorf("invalid EC key: %w", err)
+		}
+
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		key.alg, key.key = algES256, pub
+	}
+
+	return key, nil
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
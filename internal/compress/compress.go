@@ -0,0 +1,206 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const defaultMinSize = 1024
+
+var defaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+type Options struct {
+	// MinSize is the smallest Content-Length worth compressing, 1 KiB by
+	// default. Responses without Content-Length are always compressed
+	MinSize int
+	// ContentTypes are media type prefixes to compress, already compressed
+	// formats like images and archives should not be there
+	ContentTypes []string
+	// Level is passed to gzip and zlib writers, default compression if 0.
+	// It is from gzip.HuffmanOnly to gzip.BestCompression
+	Level int
+}
+
+// Middleware compresses eligible responses with encoding negotiated by
+// Accept-Encoding. Compressed responses are sent with chunked framing
+// since their length is not known in advance. It panics if level is
+// invalid
+func Middleware(opts Options) server.Middleware {
+	if opts.MinSize <= 0 {
+		opts.MinSize = defaultMinSize
+	}
+	if opts.ContentTypes == nil {
+		opts.ContentTypes = defaultContentTypes
+	}
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	if opts.Level < gzip.HuffmanOnly || opts.Level > gzip.BestCompression {
+		panic(fmt.Sprintf("invalid compression level %d", opts.Level))
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			acceptEncoding, _ := req.Headers.GetString("Accept-Encoding")
+			cw := &compressWriter{
+				writer:   w,
+				opts:     opts,
+				encoding: Negotiate(acceptEncoding, Gzip, Deflate),
+				head:     req.RequestLine.Method == "HEAD",
+			}
+
+			next(cw, req)
+
+			if err := cw.close(); err != nil {
+				slog.Error("failed to finish compressed response", "context_error", err)
+			}
+		}
+	}
+}
+
+type compressWriter struct {
+	writer   response.Writer
+	opts     Options
+	encoding string
+	head     bool
+
+	statusCode int
+	chunked    *response.ChunkedWriter
+	compressor io.WriteCloser
+}
+
+func (c *compressWriter) WriteStatusLine(statusCode int) error {
+	c.statusCode = statusCode
+	return c.writer.WriteStatusLine(statusCode)
+}
+
+func (c *compressWriter) WriteHeaders(h headers.Headers) error {
+	if !c.compressibleType(h) {
+		return c.writer.WriteHeaders(h)
+	}
+
+	// representation depends on Accept-Encoding even if not compressed now
+	if !h.HasToken("Vary", "Accept-Encoding") {
+		h.Set("Vary", "Accept-Encoding")
+	}
+
+	if c.encoding == "" || !c.eligible(h) {
+		return c.writer.WriteHeaders(h)
+	}
+
+	// compressor is made before headers are sent, so its error can still
+	// be answered
+	if !c.head {
+		c.chunked = response.NewChunkedWriter(c.writer)
+		compressor, err := newCompressor(c.encoding, c.chunked, c.opts.Level)
+		if err != nil {
+			return err
+		}
+		c.compressor = compressor
+	}
+
+	h.Delete("Content-Length")
+	h.Set("Content-Encoding", c.encoding)
+	h.Set("Transfer-Encoding", "chunked")
+
+	// encoded content is not byte-for-byte the same anymore
+	if etag, ok := h.GetString("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Change("ETag", "W/"+etag)
+	}
+
+	if err := c.writer.WriteHeaders(h); err != nil {
+		// nothing is sent after failed headers
+		c.compressor = nil
+		return err
+	}
+	return nil
+}
+
+func newCompressor(encoding string, w io.Writer, level int) (io.WriteCloser, error) {
+	if encoding == Gzip {
+		return gzip.NewWriterLevel(w, level)
+	}
+	return zlib.NewWriterLevel(w, level)
+}
+
+func (c *compressWriter) compressibleType(h headers.Headers) bool {
+	contentType, ok := h.GetElement("Content-Type")
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range c.opts.ContentTypes {
+		if strings.HasPrefix(contentType.Token, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compressWriter) eligible(h headers.Headers) bool {
+	switch c.statusCode {
+	case response.StatusNoContent, response.StatusNotModified, response.StatusPartialContent:
+		return false
+	}
+
+	if _, ok := h.GetString("Content-Encoding"); ok {
+		return false
+	}
+	if _, ok := h.GetString("Content-Range"); ok {
+		return false
+	}
+
+	if v, ok := h.GetString("Content-Length"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < c.opts.MinSize {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *compressWriter) WriteBody(p []byte) error {
+	if c.compressor == nil {
+		return c.writer.WriteBody(p)
+	}
+
+	_, err := c.compressor.Write(p)
+	return err
+}
+
+func (c *compressWriter) ReadFrom(r io.Reader) (int64, error) {
+	if c.compressor == nil {
+		return c.writer.ReadFrom(r)
+	}
+
+	return io.Copy(c.compressor, r)
+}
+
+func (c *compressWriter) close() error {
+	if c.compressor == nil {
+		return nil
+	}
+
+	if err := c.compressor.Close(); err != nil {
+		return err
+	}
+	return c.chunked.Close()
+}
@@ -0,0 +1,306 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", Gzip},
+		{"deflate", Deflate},
+		{"gzip, deflate, br", Gzip},
+		{"deflate, gzip", Gzip},
+		{"gzip;q=0.5, deflate", Deflate},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", Gzip},
+		{"*;q=0.1, gzip;q=0", Deflate},
+		{"br, identity", ""},
+		{"x-gzip", Gzip},
+		{"GZIP;Q=0.8", Gzip},
+		{"gzip;q=2", ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, Negotiate(c.accept, Gzip, Deflate), c.accept)
+	}
+}
+
+type parsedResponse struct {
+	status  string
+	headers map[string]string
+	body    string
+}
+
+func parseResponse(t *testing.T, raw string) parsedResponse {
+	t.Helper()
+	head, body, ok := strings.Cut(raw, "\r\n\r\n")
+	require.True(t, ok)
+
+	lines := strings.Split(head, "\r\n")
+	resp := parsedResponse{status: lines[0], headers: map[string]string{}, body: body}
+	for _, line := range lines[1:] {
+		k, v, _ := strings.Cut(line, ": ")
+		resp.headers[k] = v
+	}
+
+	if resp.headers["transfer-encoding"] == "chunked" && body != "" {
+		resp.body = decodeChunked(t, body)
+	}
+
+	return resp
+}
+
+func decodeChunked(t *testing.T, body string) string {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(body))
+	out := &bytes.Buffer{}
+
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+
+		if size == 0 {
+			rest, _ := io.ReadAll(r)
+			require.Equal(t, "\r\n", string(rest))
+			return out.String()
+		}
+
+		_, err = io.CopyN(out, r, size)
+		require.NoError(t, err)
+		crlf := make([]byte, 2)
+		_, err = io.ReadFull(r, crlf)
+		require.NoError(t, err)
+		require.Equal(t, "\r\n", string(crlf))
+	}
+}
+
+func handler(contentType, body string, setHeaders ...string) server.HandlerFunc {
+	return func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h.Change("Content-Type", contentType)
+		for i := 0; i+1 < len(setHeaders); i += 2 {
+			h.Set(setHeaders[i], setHeaders[i+1])
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		if req.RequestLine.Method != "HEAD" {
+			w.WriteBody([]byte(body[:len(body)/2]))
+			w.ReadFrom(strings.NewReader(body[len(body)/2:]))
+		}
+	}
+}
+
+func serve(t *testing.T, h server.HandlerFunc, method string, requestHeaders ...string) parsedResponse {
+	t.Helper()
+	raw := method + " / HTTP/1.1\r\nHost: localhost\r\n"
+	for _, rh := range requestHeaders {
+		raw += rh + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+	return parseResponse(t, buf.String())
+}
+
+func TestMiddleware(t *testing.T) {
+	text := strings.Repeat("compress me please, ", 200)
+	mw := Middleware(Options{})
+
+	t.Run("ok, gzip", func(t *testing.T) {
+		resp := serve(t, mw(handler("text/plain", text, "ETag", `"v1"`)), "GET", "Accept-Encoding: gzip, deflate")
+		assert.Equal(t, "gzip", resp.headers["content-encoding"])
+		assert.Equal(t, "chunked", resp.headers["transfer-encoding"])
+		assert.Equal(t, "Accept-Encoding", resp.headers["vary"])
+		assert.Equal(t, `W/"v1"`, resp.headers["etag"])
+		assert.NotContains(t, resp.headers, "content-length")
+
+		zr, err := gzip.NewReader(strings.NewReader(resp.body))
+		require.NoError(t, err)
+		data, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, text, string(data))
+		assert.Less(t, len(resp.body), len(text))
+	})
+	t.Run("ok, deflate", func(t *testing.T) {
+		resp := serve(t, mw(handler("application/json", text)), "GET", "Accept-Encoding: deflate")
+		assert.Equal(t, "deflate", resp.headers["content-encoding"])
+
+		zr, err := zlib.NewReader(strings.NewReader(resp.body))
+		require.NoError(t, err)
+		data, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, text, string(data))
+	})
+	t.Run("ok, not accepted", func(t *testing.T) {
+		resp := serve(t, mw(handler("text/plain", text)), "GET")
+		assert.NotContains(t, resp.headers, "content-encoding")
+		assert.Equal(t, "Accept-Encoding", resp.headers["vary"])
+		assert.Equal(t, strconv.Itoa(len(text)), resp.headers["content-length"])
+		assert.Equal(t, text, resp.body)
+	})
+	t.Run("ok, below threshold", func(t *testing.T) {
+		resp := serve(t, mw(handler("text/plain", "tiny")), "GET", "Accept-Encoding: gzip")
+		assert.NotContains(t, resp.headers, "content-encoding")
+		assert.Equal(t, "tiny", resp.body)
+	})
+	t.Run("ok, already compressed type", func(t *testing.T) {
+		resp := serve(t, mw(handler("image/png", text)), "GET", "Accept-Encoding: gzip")
+		assert.NotContains(t, resp.headers, "content-encoding")
+		assert.NotContains(t, resp.headers, "vary")
+		assert.Equal(t, text, resp.body)
+	})
+	t.Run("ok, already encoded", func(t *testing.T) {
+		resp := serve(t, mw(handler("text/plain", text, "Content-Encoding", "br")), "GET", "Accept-Encoding: gzip")
+		assert.Equal(t, "br", resp.headers["content-encoding"])
+		assert.Equal(t, text, resp.body)
+	})
+	t.Run("ok, head", func(t *testing.T) {
+		resp := serve(t, mw(handler("text/plain", text)), "HEAD", "Accept-Encoding: gzip")
+		assert.Equal(t, "gzip", resp.headers["content-encoding"])
+		assert.Empty(t, resp.body)
+	})
+	t.Run("ok, error statuses without body", func(t *testing.T) {
+		h := func(w response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusNotModified)
+			h := response.GetDefaultHeaders(0)
+			w.WriteHeaders(h)
+		}
+		resp := serve(t, mw(h), "GET", "Accept-Encoding: gzip")
+		assert.NotContains(t, resp.headers, "content-encoding")
+	})
+	t.Run("ok, best speed", func(t *testing.T) {
+		resp := serve(t, Middleware(Options{Level: gzip.BestSpeed})(handler("text/plain", text)), "GET", "Accept-Encoding: gzip")
+		assert.Equal(t, "gzip", resp.headers["content-encoding"])
+	})
+	t.Run("fail, invalid level", func(t *testing.T) {
+		assert.Panics(t, func() { Middleware(Options{Level: 42}) })
+		assert.Panics(t, func() { Middleware(Options{Level: -3}) })
+	})
+}
+
+func postRequest(t *testing.T, encoding string, body []byte) *request.Request {
+	t.Helper()
+	raw := "POST /telemetry HTTP/1.1\r\nHost: localhost\r\n"
+	if encoding != "" {
+		raw += "Content-Encoding: " + encoding + "\r\n"
+	}
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func TestDecodeRequest(t *testing.T) {
+	payload := `{"event":"login","count":3}`
+
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	gw.Write([]byte(payload))
+	gw.Close()
+
+	deflated := &bytes.Buffer{}
+	zw := zlib.NewWriter(deflated)
+	zw.Write([]byte(payload))
+	zw.Close()
+
+	var gotBody, gotEncoding, gotLength string
+	var gotContentEncoding bool
+	echo := func(w response.Writer, req *request.Request) {
+		gotBody = string(req.Body)
+		gotEncoding = RequestEncoding(req)
+		gotLength, _ = req.Headers.GetString("Content-Length")
+		_, gotContentEncoding = req.Headers.GetString("Content-Encoding")
+		handler("text/plain", "ok")(w, req)
+	}
+
+	decode := func(t *testing.T, opts DecodeOptions, req *request.Request) parsedResponse {
+		t.Helper()
+		gotBody, gotEncoding, gotLength, gotContentEncoding = "", "", "", false
+		buf := &bytes.Buffer{}
+		DecodeRequest(opts)(echo)(response.NewWriter(buf), req)
+		return parseResponse(t, buf.String())
+	}
+
+	t.Run("ok, gzip", func(t *testing.T) {
+		req := postRequest(t, "gzip", gzipped.Bytes())
+		resp := decode(t, DecodeOptions{}, req)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.status)
+		assert.Equal(t, payload, gotBody)
+		assert.Equal(t, "gzip", gotEncoding)
+		assert.Equal(t, strconv.Itoa(len(payload)), gotLength)
+		assert.False(t, gotContentEncoding)
+
+		// request of outer middlewares is not changed
+		encoding, _ := req.Headers.GetString("Content-Encoding")
+		assert.Equal(t, "gzip", encoding)
+		length, _ := req.Headers.GetString("Content-Length")
+		assert.Equal(t, strconv.Itoa(gzipped.Len()), length)
+	})
+
+	t.Run("ok, deflate", func(t *testing.T) {
+		decode(t, DecodeOptions{}, postRequest(t, "deflate", deflated.Bytes()))
+		assert.Equal(t, payload, gotBody)
+		assert.Equal(t, "deflate", gotEncoding)
+	})
+
+	t.Run("ok, stacked codings", func(t *testing.T) {
+		twice := &bytes.Buffer{}
+		gw := gzip.NewWriter(twice)
+		gw.Write(deflated.Bytes())
+		gw.Close()
+
+		decode(t, DecodeOptions{}, postRequest(t, "deflate, gzip", twice.Bytes()))
+		assert.Equal(t, payload, gotBody)
+		assert.Equal(t, "deflate, gzip", gotEncoding)
+	})
+
+	t.Run("ok, no encoding", func(t *testing.T) {
+		decode(t, DecodeOptions{}, postRequest(t, "", []byte(payload)))
+		assert.Equal(t, payload, gotBody)
+		assert.Equal(t, "", gotEncoding)
+	})
+
+	t.Run("fail, unsupported encoding", func(t *testing.T) {
+		resp := decode(t, DecodeOptions{}, postRequest(t, "br", []byte(payload)))
+		assert.Equal(t, "HTTP/1.1 415 Unsupported Media Type", resp.status)
+		assert.Equal(t, "gzip, deflate", resp.headers["accept-encoding"])
+		assert.Equal(t, "", gotBody)
+	})
+
+	t.Run("fail, too large", func(t *testing.T) {
+		bomb := &bytes.Buffer{}
+		gw := gzip.NewWriter(bomb)
+		gw.Write(bytes.Repeat([]byte{0}, 1<<20))
+		gw.Close()
+
+		resp := decode(t, DecodeOptions{MaxSize: 1024}, postRequest(t, "gzip", bomb.Bytes()))
+		assert.Equal(t, "HTTP/1.1 413 Content Too Large", resp.status)
+		assert.Equal(t, "", gotBody)
+	})
+
+	t.Run("fail, corrupt body", func(t *testing.T) {
+		resp := decode(t, DecodeOptions{}, postRequest(t, "gzip", []byte(payload)))
+		assert.Equal(t, "HTTP/1.1 400 Bad Request", resp.status)
+	})
+}
@@ -0,0 +1,133 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const defaultMaxDecodedSize = 10 << 20
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errTooLarge            = errors.New("decoded body is too large")
+)
+
+type DecodeOptions struct {
+	// MaxSize limits decoded body to protect from decompression bombs,
+	// 10 MiB by default
+	MaxSize int64
+}
+
+type encodingKey struct{}
+
+// RequestEncoding returns Content-Encoding the request body had before
+// DecodeRequest decoded it
+func RequestEncoding(req *request.Request) string {
+	encoding, _ := req.Context().Value(encodingKey{}).(string)
+	return encoding
+}
+
+// DecodeRequest decodes gzip and deflate request bodies before passing
+// them to handler. Unsupported encodings are answered with 415, bodies
+// larger than MaxSize after decoding with 413
+func DecodeRequest(opts DecodeOptions) server.Middleware {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxDecodedSize
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			encoding, ok := req.Headers.GetString("Content-Encoding")
+			if !ok || len(req.Body) == 0 {
+				next(w, req)
+				return
+			}
+
+			body, err := decodeBody(req.Body, encoding, opts.MaxSize)
+
+			switch {
+			case errors.Is(err, errUnsupportedEncoding):
+				writeUnsupported(w, req)
+				return
+			case errors.Is(err, errTooLarge):
+				server.RenderError(w, req, server.NewHandlerError(response.StatusContentTooLarge, ""))
+				return
+			case err != nil:
+				slog.Info("failed to decode request body", "encoding", encoding, "context_error", err)
+				server.RenderError(w, req, server.NewHandlerError(response.StatusBadRequset, "failed to decode body"))
+				return
+			}
+
+			// req is a copy, headers are cloned so outer middlewares still
+			// see the request as it was received
+			req = req.WithContext(context.WithValue(req.Context(), encodingKey{}, encoding))
+			req.Body = body
+			req.Headers = maps.Clone(req.Headers)
+			req.Headers.Delete("Content-Encoding")
+			req.Headers.Change("Content-Length", strconv.Itoa(len(body)))
+
+			next(w, req)
+		}
+	}
+}
+
+// decodeBody removes codings in reverse order they were applied
+func decodeBody(body []byte, encoding string, maxSize int64) ([]byte, error) {
+	codings := headers.SplitList(encoding)
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(codings[i])
+
+		var r io.Reader
+		var err error
+
+		switch coding {
+		case identity:
+			continue
+		case Gzip, "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case Deflate:
+			r, err = zlib.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, coding)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(decoded)) > maxSize {
+			return nil, errTooLarge
+		}
+
+		body = decoded
+	}
+
+	return body, nil
+}
+
+func writeUnsupported(w response.Writer, req *request.Request) {
+	ow := response.Observe(w)
+	ow.OnHeaders(func(_ int, h headers.Headers) {
+		// tells client which encodings it may use, RFC 9110 section 12.5.3
+		h.Set("Accept-Encoding", Gzip+", "+Deflate)
+	})
+	server.RenderError(ow, req, server.NewHandlerError(response.StatusUnsupportedMedia, ""))
+}
@@ -0,0 +1,47 @@
+package compress
+
+import "github.com/SSL0/http-impl/internal/headers"
+
+const (
+	Gzip     = "gzip"
+	Deflate  = "deflate"
+	identity = "identity"
+)
+
+// Negotiate picks encoding from supported with the highest q-value in
+// Accept-Encoding, earlier supported encodings win ties. Empty string
+// means the content should be sent as is
+func Negotiate(acceptEncoding string, supported ...string) string {
+	weights := map[string]float64{}
+	wildcard := -1.0
+
+	for _, pref := range headers.ParsePreferences(acceptEncoding) {
+		coding := pref.Value
+
+		// x-gzip is an alias, RFC 9110 section 8.4.1.3
+		if coding == "x-gzip" {
+			coding = Gzip
+		}
+
+		if coding == "*" {
+			wildcard = pref.Q
+		} else if _, ok := weights[coding]; !ok {
+			weights[coding] = pref.Q
+		}
+	}
+
+	best := ""
+	bestQ := 0.0
+
+	for _, coding := range supported {
+		q, ok := weights[coding]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,63 @@
+package conditional
+
+import (
+	"strings"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+// Evaluate checks request preconditions against validators of the
+// selected representation in order of RFC 9110 section 13.2.2. It returns
+// 0 when request should be processed, 304 or 412 otherwise. Zero etag or
+// lastModified means the validator is unknown
+func Evaluate(req *request.Request, etag string, lastModified time.Time) int {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+	lastModified = lastModified.Truncate(time.Second)
+
+	if ifMatch, ok := req.Headers.GetString("If-Match"); ok {
+		if !matchesAny(ifMatch, etag, strongMatch) {
+			return response.StatusPreconditionFailed
+		}
+	} else if t, ok := req.Headers.GetTime("If-Unmodified-Since"); ok && !lastModified.IsZero() && lastModified.After(t) {
+		return response.StatusPreconditionFailed
+	}
+
+	if inm, ok := req.Headers.GetString("If-None-Match"); ok {
+		if matchesAny(inm, etag, weakMatch) {
+			if safe {
+				return response.StatusNotModified
+			}
+			return response.StatusPreconditionFailed
+		}
+	} else if t, ok := req.Headers.GetTime("If-Modified-Since"); ok && safe && !lastModified.IsZero() && !lastModified.After(t) {
+		return response.StatusNotModified
+	}
+
+	return 0
+}
+
+func strongMatch(a, b string) bool {
+	return a == b && !strings.HasPrefix(a, "W/")
+}
+
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// matchesAny reports whether etag is in the comma separated list, "*"
+// matches any existing representation
+func matchesAny(list, etag string, match func(a, b string) bool) bool {
+	for _, candidate := range headers.SplitList(list) {
+		if candidate == "*" {
+			return etag != ""
+		}
+		if etag != "" && match(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
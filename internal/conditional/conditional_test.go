@@ -0,0 +1,221 @@
+package conditional
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, method string, requestHeaders ...string) *request.Request {
+	t.Helper()
+	raw := method + " /items HTTP/1.1\r\nHost: localhost\r\n"
+	for _, h := range requestHeaders {
+		raw += h + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestEvaluate(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(headers.TimeFormat)
+	after := modified.Add(time.Hour).Format(headers.TimeFormat)
+	etag := `"v2"`
+
+	cases := []struct {
+		name    string
+		method  string
+		headers []string
+		want    int
+	}{
+		{"no preconditions", "GET", nil, 0},
+		{"if-match ok", "PUT", []string{`If-Match: "v1", "v2"`}, 0},
+		{"if-match star", "PUT", []string{`If-Match: *`}, 0},
+		{"if-match failed", "PUT", []string{`If-Match: "v1"`}, response.StatusPreconditionFailed},
+		{"if-match weak never matches", "PUT", []string{`If-Match: W/"v2"`}, response.StatusPreconditionFailed},
+		{"if-unmodified-since ok", "PUT", []string{"If-Unmodified-Since: " + after}, 0},
+		{"if-unmodified-since failed", "PUT", []string{"If-Unmodified-Since: " + before}, response.StatusPreconditionFailed},
+		{"if-match wins over if-unmodified-since", "PUT", []string{`If-Match: "v2"`, "If-Unmodified-Since: " + before}, 0},
+		{"if-none-match get", "GET", []string{`If-None-Match: "v1", W/"v2"`}, response.StatusNotModified},
+		{"if-none-match head", "HEAD", []string{`If-None-Match: *`}, response.StatusNotModified},
+		{"if-none-match put", "PUT", []string{`If-None-Match: *`}, response.StatusPreconditionFailed},
+		{"if-none-match no match", "GET", []string{`If-None-Match: "v1"`}, 0},
+		{"if-modified-since not modified", "GET", []string{"If-Modified-Since: " + after}, response.StatusNotModified},
+		{"if-modified-since modified", "GET", []string{"If-Modified-Since: " + before}, 0},
+		{"if-modified-since ignored for put", "PUT", []string{"If-Modified-Since: " + after}, 0},
+		{"if-modified-since invalid date", "GET", []string{"If-Modified-Since: yesterday"}, 0},
+		{"if-none-match wins over if-modified-since", "GET", []string{`If-None-Match: "v1"`, "If-Modified-Since: " + after}, 0},
+		{"if-match checked before if-none-match", "GET", []string{`If-Match: "v1"`, `If-None-Match: "v2"`}, response.StatusPreconditionFailed},
+		{"quoted comma in list", "GET", []string{`If-None-Match: "a,b", "v2"`}, response.StatusNotModified},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, Evaluate(newRequest(t, c.method, c.headers...), etag, modified))
+		})
+	}
+
+	t.Run("if-match star without representation", func(t *testing.T) {
+		assert.Equal(t, response.StatusPreconditionFailed, Evaluate(newRequest(t, "PUT", "If-Match: *"), "", time.Time{}))
+	})
+}
+
+func jsonHandler(body string) server.HandlerFunc {
+	return func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h.Change("Content-Type", "application/json")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	body := `{"items":[1,2,3]}`
+	etag := GenerateETag([]byte(body), false)
+
+	serve := func(h server.HandlerFunc, req *request.Request) string {
+		buf := &bytes.Buffer{}
+		h(response.NewWriter(buf), req)
+		return buf.String()
+	}
+
+	h := Middleware(Options{})(jsonHandler(body))
+
+	t.Run("ok, etag added", func(t *testing.T) {
+		resp := serve(h, newRequest(t, "GET"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, resp, "etag: "+etag+"\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"+body))
+	})
+	t.Run("ok, weak etag", func(t *testing.T) {
+		resp := serve(Middleware(Options{Weak: true})(jsonHandler(body)), newRequest(t, "GET"))
+		assert.Contains(t, resp, "etag: W/"+etag+"\r\n")
+	})
+	t.Run("ok, not modified without body", func(t *testing.T) {
+		resp := serve(h, newRequest(t, "GET", "If-None-Match: "+etag))
+		assert.Contains(t, resp, "HTTP/1.1 304 Not Modified\r\n")
+		assert.Contains(t, resp, "etag: "+etag+"\r\n")
+		assert.NotContains(t, resp, "content-type")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+	})
+	t.Run("ok, precondition failed", func(t *testing.T) {
+		resp := serve(h, newRequest(t, "GET", `If-Match: "other"`))
+		assert.Contains(t, resp, "HTTP/1.1 412 Precondition Failed\r\n")
+		assert.NotContains(t, resp, body)
+	})
+	t.Run("ok, handler etag and last-modified are used", func(t *testing.T) {
+		lastModified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Format(headers.TimeFormat)
+		custom := func(w response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(len(body))
+			h.Set("ETag", `"custom"`)
+			h.Set("Last-Modified", lastModified)
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(body))
+		}
+		mw := Middleware(Options{})(custom)
+
+		assert.Contains(t, serve(mw, newRequest(t, "GET", `If-None-Match: "custom"`)), "304 Not Modified")
+		assert.Contains(t, serve(mw, newRequest(t, "GET", "If-Modified-Since: "+lastModified)), "304 Not Modified")
+	})
+	t.Run("ok, other statuses and methods pass through", func(t *testing.T) {
+		notFound := func(w response.Writer, req *request.Request) {
+			server.NewHandlerError(response.StatusNotFound, "").Write(w)
+		}
+		resp := serve(Middleware(Options{})(notFound), newRequest(t, "GET", "If-None-Match: *"))
+		assert.Contains(t, resp, "HTTP/1.1 404 Not Found\r\n")
+		assert.NotContains(t, resp, "etag")
+
+		resp = serve(h, newRequest(t, "POST", "If-None-Match: *"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+		assert.NotContains(t, resp, "etag")
+	})
+	t.Run("ok, head has etag of get without body", func(t *testing.T) {
+		resp := serve(h, newRequest(t, "HEAD"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, resp, "etag: "+etag+"\r\n")
+		assert.Contains(t, resp, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+		assert.Contains(t, serve(h, newRequest(t, "HEAD", "If-None-Match: "+etag)), "HTTP/1.1 304 Not Modified\r\n")
+	})
+	t.Run("ok, unsafe methods checked with validator", func(t *testing.T) {
+		called := false
+		update := func(w response.Writer, req *request.Request) {
+			called = true
+			w.WriteStatusLine(response.StatusNoContent)
+			w.WriteHeaders(response.GetNoContentHeaders())
+		}
+		current := `"v2"`
+		validator := func(req *request.Request) (string, time.Time, error) {
+			return current, time.Time{}, nil
+		}
+		mw := Middleware(Options{Validator: validator})(update)
+
+		resp := serve(mw, newRequest(t, "PUT", `If-Match: "v1"`))
+		assert.Contains(t, resp, "HTTP/1.1 412 Precondition Failed\r\n")
+		assert.False(t, called)
+
+		resp = serve(mw, newRequest(t, "PUT", "If-None-Match: *"))
+		assert.Contains(t, resp, "HTTP/1.1 412 Precondition Failed\r\n")
+		assert.False(t, called)
+
+		resp = serve(mw, newRequest(t, "DELETE", `If-Match: "v2"`))
+		assert.Contains(t, resp, "HTTP/1.1 204 No Content\r\n")
+		assert.True(t, called)
+
+		// resource doesn't exist yet
+		current = ""
+		called = false
+		assert.Contains(t, serve(mw, newRequest(t, "PUT", "If-None-Match: *")), "HTTP/1.1 204 No Content\r\n")
+		assert.True(t, called)
+	})
+	t.Run("fail, validator error", func(t *testing.T) {
+		validator := func(req *request.Request) (string, time.Time, error) {
+			return "", time.Time{}, errors.New("storage is down")
+		}
+		resp := serve(Middleware(Options{Validator: validator})(h), newRequest(t, "PATCH", `If-Match: "v1"`))
+		assert.Contains(t, resp, "HTTP/1.1 500 Internal Server Error\r\n")
+		assert.NotContains(t, resp, "storage")
+	})
+	t.Run("ok, large body is not buffered", func(t *testing.T) {
+		large := strings.Repeat("x", 100)
+		mw := Middleware(Options{MaxSize: 10})(jsonHandler(large))
+		resp := serve(mw, newRequest(t, "GET", "If-None-Match: *"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+		assert.NotContains(t, resp, "etag")
+		assert.True(t, strings.HasSuffix(resp, large))
+
+		streaming := func(w response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(large)))
+			w.ReadFrom(strings.NewReader(large))
+		}
+		resp = serve(Middleware(Options{MaxSize: 10})(streaming), newRequest(t, "GET"))
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"+large))
+	})
+	t.Run("ok, status is sent when handler panics", func(t *testing.T) {
+		panicking := func(w response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			panic("boom")
+		}
+		ow := response.Observe(response.NewWriter(&bytes.Buffer{}))
+
+		assert.PanicsWithValue(t, "boom", func() {
+			Middleware(Options{})(panicking)(ow, newRequest(t, "GET"))
+		})
+		assert.True(t, ow.WroteStatusLine())
+	})
+}
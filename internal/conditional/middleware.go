@@ -0,0 +1,246 @@
+package conditional
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const defaultMaxSize = 1 << 20
+
+// headers kept in 304 response, RFC 9110 section 15.4.5
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Vary", "Last-Modified"}
+
+// Validator returns validators of the current representation of the
+// resource req targets, empty etag and zero time if there is none
+type Validator func(req *request.Request) (etag string, lastModified time.Time, err error)
+
+type Options struct {
+	// Weak makes generated ETags weak, use it when representation may be
+	// encoded differently with the same meaning
+	Weak bool
+	// MaxSize limits buffered body, larger responses are sent as is.
+	// 1 MiB by default
+	MaxSize int
+	// Validator lets preconditions of other methods than GET and HEAD be
+	// checked before handler changes anything, e.g. If-Match of PUT. They
+	// are passed to handler unchecked without it
+	Validator Validator
+}
+
+// Middleware buffers successful GET and HEAD responses, adds ETag
+// computed from the body unless handler set one, and answers 304 or 412
+// according to request preconditions. Other methods are answered 412
+// without calling handler if their preconditions fail on Validator
+func Middleware(opts Options) server.Middleware {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			method := req.RequestLine.Method
+			if method != "GET" && method != "HEAD" {
+				if opts.Validator != nil && !checkUnsafe(w, req, opts.Validator) {
+					return
+				}
+				next(w, req)
+				return
+			}
+
+			// body of HEAD is buffered for ETag and dropped then
+			if method == "HEAD" {
+				w = response.DiscardBody(w)
+			}
+
+			bw := &bufferedWriter{writer: w, maxSize: opts.MaxSize}
+			returned := false
+			defer func() {
+				// handler panicked after starting its response, the held
+				// status is sent so the panic aborts connection instead of
+				// being answered with 500
+				if !returned && bw.buffering {
+					w.WriteStatusLine(response.StatusOK)
+				}
+			}()
+
+			next(bw, req)
+			returned = true
+
+			if !bw.buffering {
+				return
+			}
+
+			if err := bw.finish(req, opts.Weak); err != nil {
+				slog.Error("failed to write buffered response", "context_error", err)
+			}
+		}
+	}
+}
+
+// checkUnsafe reports whether request may be passed to handler, it writes
+// response otherwise
+func checkUnsafe(w response.Writer, req *request.Request, validator Validator) bool {
+	etag, lastModified, err := validator(req)
+	if err != nil {
+		slog.Error("failed to get validators", "context_error", err)
+		server.RenderError(w, req, server.NewHandlerError(response.StatusInternalServerError, ""))
+		return false
+	}
+
+	if Evaluate(req, etag, lastModified) != 0 {
+		server.RenderError(w, req, server.NewHandlerError(response.StatusPreconditionFailed, ""))
+		return false
+	}
+	return true
+}
+
+func GenerateETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:16]))
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// bufferedWriter holds 200 responses until handler returns, other
+// statuses are passed through
+type bufferedWriter struct {
+	writer    response.Writer
+	maxSize   int
+	buffering bool
+	headers   headers.Headers
+	body      bytes.Buffer
+}
+
+func (b *bufferedWriter) WriteStatusLine(statusCode int) error {
+	if statusCode == response.StatusOK {
+		b.buffering = true
+		return nil
+	}
+	return b.writer.WriteStatusLine(statusCode)
+}
+
+func (b *bufferedWriter) WriteHeaders(h headers.Headers) error {
+	if !b.buffering {
+		return b.writer.WriteHeaders(h)
+	}
+	b.headers = h
+	return nil
+}
+
+func (b *bufferedWriter) WriteBody(p []byte) error {
+	if !b.buffering {
+		return b.writer.WriteBody(p)
+	}
+
+	if b.body.Len()+len(p) > b.maxSize {
+		if err := b.flush(); err != nil {
+			return err
+		}
+		return b.writer.WriteBody(p)
+	}
+
+	b.body.Write(p)
+	return nil
+}
+
+func (b *bufferedWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !b.buffering {
+		return b.writer.ReadFrom(r)
+	}
+
+	n, err := b.body.ReadFrom(io.LimitReader(r, int64(b.maxSize-b.body.Len()+1)))
+	if err != nil || b.body.Len() <= b.maxSize {
+		return n, err
+	}
+
+	if err := b.flush(); err != nil {
+		return n, err
+	}
+	m, err := b.writer.ReadFrom(r)
+	return n + m, err
+}
+
+// flush gives up on buffering and writes what is held so far
+func (b *bufferedWriter) flush() error {
+	b.buffering = false
+
+	if err := b.writer.WriteStatusLine(response.StatusOK); err != nil {
+		return err
+	}
+	if err := b.writer.WriteHeaders(b.headers); err != nil {
+		return err
+	}
+	return b.writer.WriteBody(b.body.Bytes())
+}
+
+func (b *bufferedWriter) finish(req *request.Request, weak bool) error {
+	h := b.headers
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+
+	etag, ok := h.GetString("ETag")
+	if !ok && b.hasWholeBody(h) {
+		etag = GenerateETag(b.body.Bytes(), weak)
+		h.Set("ETag", etag)
+	}
+
+	lastModified, _ := h.GetTime("Last-Modified")
+
+	switch status := Evaluate(req, etag, lastModified); status {
+	case response.StatusNotModified:
+		kept := headers.NewHeaders()
+		for _, name := range notModifiedHeaders {
+			if v, ok := h.GetString(name); ok {
+				kept.Set(name, v)
+			}
+		}
+		if v, ok := h.GetString("Connection"); ok {
+			kept.Set("Connection", v)
+		}
+
+		if err := b.writer.WriteStatusLine(status); err != nil {
+			return err
+		}
+		return b.writer.WriteHeaders(kept)
+	case response.StatusPreconditionFailed:
+		server.RenderError(b.writer, req, server.NewHandlerError(status, ""))
+		return nil
+	}
+
+	if _, ok := h.GetString("Content-Length"); !ok && (req.RequestLine.Method == "GET" || b.body.Len() > 0) {
+		h.Set("Content-Length", strconv.Itoa(b.body.Len()))
+	}
+
+	if err := b.writer.WriteStatusLine(response.StatusOK); err != nil {
+		return err
+	}
+	if err := b.writer.WriteHeaders(h); err != nil {
+		return err
+	}
+	if b.body.Len() == 0 {
+		return nil
+	}
+	return b.writer.WriteBody(b.body.Bytes())
+}
+
+// hasWholeBody reports whether the buffered body is the representation.
+// Handlers which skip body of HEAD themselves leave it empty, its ETag
+// would differ from the one of GET then
+func (b *bufferedWriter) hasWholeBody(h headers.Headers) bool {
+	length, ok := h.GetString("Content-Length")
+	return !ok || length == strconv.Itoa(b.body.Len())
+}
@@ -0,0 +1,195 @@
+package cors
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+var defaultMethods = []string{"GET", "HEAD", "POST"}
+
+type Options struct {
+	// AllowedOrigins are exact origins like "https://example.com",
+	// wildcard subdomains like "https://*.example.com" or "*" for any
+	// origin. No origin is allowed if it is empty
+	AllowedOrigins []string
+	// AllowedOriginPatterns have to match the whole lowercased origin
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods are GET, HEAD and POST by default
+	AllowedMethods []string
+	// AllowedHeaders can be sent in addition to CORS-safelisted ones, "*"
+	// allows any
+	AllowedHeaders []string
+	// ExposedHeaders can be read by scripts in addition to CORS-safelisted
+	// response headers
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and Authorization. It can't be used
+	// with "*" origin, that would let any site read responses of the user
+	AllowCredentials bool
+	// MaxAge is how long preflight result can be cached, omitted if zero
+	MaxAge time.Duration
+}
+
+// Middleware adds CORS headers of Fetch standard to responses for allowed
+// origins. Preflight requests are answered with 204 without calling
+// handler. It panics if options are invalid
+func Middleware(opts Options) server.Middleware {
+	if err := opts.Validate(); err != nil {
+		panic(err)
+	}
+	if opts.AllowedMethods == nil {
+		opts.AllowedMethods = defaultMethods
+	}
+
+	// leftmost-first match of "a|a\.b" doesn't span "a.b", so patterns are
+	// anchored instead of checking the match bounds
+	patterns := make([]*regexp.Regexp, len(opts.AllowedOriginPatterns))
+	for i, pattern := range opts.AllowedOriginPatterns {
+		patterns[i] = regexp.MustCompile(`^(?:` + pattern.String() + `)$`)
+	}
+	opts.AllowedOriginPatterns = patterns
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			origin, hasOrigin := req.Headers.GetString("Origin")
+			requestMethod, isPreflight := req.Headers.GetString("Access-Control-Request-Method")
+			isPreflight = isPreflight && hasOrigin && req.RequestLine.Method == "OPTIONS"
+
+			if isPreflight {
+				opts.writePreflight(w, req, origin, requestMethod)
+				return
+			}
+
+			ow := response.Observe(w)
+			ow.OnHeaders(func(_ int, h headers.Headers) {
+				// response depends on Origin even when it is not allowed,
+				// so caches must not reuse it for other origins
+				addVary(h, "Origin")
+				if !hasOrigin || !opts.allowedOrigin(origin) {
+					return
+				}
+
+				// values set by handler are replaced, so they aren't
+				// combined into invalid lists
+				replace(h, "Access-Control-Allow-Origin", opts.allowOriginValue(origin))
+				if opts.AllowCredentials {
+					replace(h, "Access-Control-Allow-Credentials", "true")
+				}
+				if len(opts.ExposedHeaders) > 0 {
+					replace(h, "Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+			})
+
+			next(ow, req)
+		}
+	}
+}
+
+// Validate checks that credentials are not allowed for any origin
+func (o Options) Validate() error {
+	if o.AllowCredentials && slices.Contains(o.AllowedOrigins, "*") {
+		return fmt.Errorf("credentials can't be allowed for any origin, got origins %q", o.AllowedOrigins)
+	}
+	return nil
+}
+
+// writePreflight answers with CORS headers only if origin, method and
+// headers are allowed, otherwise browser fails the actual request
+func (o Options) writePreflight(w response.Writer, req *request.Request, origin, method string) {
+	h := response.GetNoContentHeaders()
+	addVary(h, "Origin")
+	addVary(h, "Access-Control-Request-Method")
+	addVary(h, "Access-Control-Request-Headers")
+
+	requested := req.Headers.GetList("Access-Control-Request-Headers")
+
+	if o.allowedOrigin(origin) && slices.Contains(o.AllowedMethods, method) && o.allowedHeaders(requested) {
+		h.Set("Access-Control-Allow-Origin", o.allowOriginValue(origin))
+		h.Set("Access-Control-Allow-Methods", strings.Join(o.AllowedMethods, ", "))
+		if len(requested) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.ToLower(strings.Join(requested, ", ")))
+		}
+		if o.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if o.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(o.MaxAge/time.Second)))
+		}
+	}
+
+	w.WriteStatusLine(response.StatusNoContent)
+	w.WriteHeaders(h)
+}
+
+func (o Options) allowOriginValue(origin string) string {
+	if slices.Contains(o.AllowedOrigins, "*") {
+		return "*"
+	}
+	return origin
+}
+
+func (o Options) allowedOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) || matchWildcard(strings.ToLower(allowed), origin) {
+			return true
+		}
+	}
+
+	for _, pattern := range o.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard matches origin with pattern like "https://*.example.com",
+// the wildcard is one or more subdomain labels
+func matchWildcard(pattern, origin string) bool {
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok || len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(subdomain, "/:@") && !strings.HasPrefix(subdomain, ".")
+}
+
+func (o Options) allowedHeaders(requested []string) bool {
+	if slices.Contains(o.AllowedHeaders, "*") {
+		return true
+	}
+
+	for _, name := range requested {
+		if !slices.ContainsFunc(o.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, name)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// addVary adds name to Vary keeping values set by handler
+func addVary(h headers.Headers, name string) {
+	if h.HasToken("Vary", name) || h.HasToken("Vary", "*") {
+		return
+	}
+	h.Set("Vary", name)
+}
+
+func replace(h headers.Headers, key, value string) {
+	h.Delete(key)
+	h.Set(key, value)
+}
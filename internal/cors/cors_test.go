@@ -0,0 +1,143 @@
+package cors
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, h server.HandlerFunc, method string, extra ...string) string {
+	t.Helper()
+	raw := method + " /api HTTP/1.1\r\nHost: api.example.com\r\n"
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func TestMiddleware(t *testing.T) {
+	called := false
+	handler := func(w response.Writer, req *request.Request) {
+		called = true
+		body := []byte("ok")
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Vary", "Accept-Encoding")
+		h.Set("Access-Control-Allow-Origin", "https://stale.example")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+
+	h := Middleware(Options{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`http://localhost:\d+`)},
+		AllowedMethods:        []string{"GET", "PUT"},
+		AllowedHeaders:        []string{"Content-Type", "X-Request-ID"},
+		ExposedHeaders:        []string{"ETag", "RateLimit-Remaining"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	})(handler)
+
+	t.Run("ok, allowed origins", func(t *testing.T) {
+		for _, origin := range []string{"https://app.example.com", "https://a.b.example.org", "HTTPS://API.example.org", "http://localhost:3000"} {
+			resp := serve(t, h, "GET", "Origin: "+origin)
+			assert.Contains(t, resp, "access-control-allow-origin: "+origin+"\r\n", origin)
+			assert.Contains(t, resp, "access-control-allow-credentials: true\r\n", origin)
+			assert.Contains(t, resp, "access-control-expose-headers: ETag, RateLimit-Remaining\r\n", origin)
+			assert.Contains(t, resp, "vary: Accept-Encoding, Origin\r\n", origin)
+		}
+	})
+
+	t.Run("fail, origins not allowed", func(t *testing.T) {
+		for _, origin := range []string{"https://evil.com", "https://example.org", "https://.example.org", "https://evil.com/.example.org",
+			"http://app.example.com", "http://localhost:3000.evil.com", "null"} {
+			resp := serve(t, h, "GET", "Origin: "+origin)
+			assert.Contains(t, resp, "HTTP/1.1 200 OK", origin)
+			assert.Contains(t, resp, "access-control-allow-origin: https://stale.example\r\n", origin)
+			assert.NotContains(t, resp, "access-control-allow-credentials", origin)
+			assert.Contains(t, resp, "vary: Accept-Encoding, Origin\r\n", origin)
+		}
+	})
+
+	t.Run("ok, vary without origin", func(t *testing.T) {
+		resp := serve(t, h, "GET")
+		assert.Contains(t, resp, "vary: Accept-Encoding, Origin\r\n")
+	})
+
+	t.Run("ok, preflight", func(t *testing.T) {
+		called = false
+		resp := serve(t, h, "OPTIONS",
+			"Origin: https://app.example.com",
+			"Access-Control-Request-Method: PUT",
+			"Access-Control-Request-Headers: content-type, X-Request-ID")
+
+		assert.False(t, called)
+		assert.Contains(t, resp, "HTTP/1.1 204 No Content")
+		assert.Contains(t, resp, "access-control-allow-origin: https://app.example.com\r\n")
+		assert.Contains(t, resp, "access-control-allow-methods: GET, PUT\r\n")
+		assert.Contains(t, resp, "access-control-allow-headers: content-type, x-request-id\r\n")
+		assert.Contains(t, resp, "access-control-allow-credentials: true\r\n")
+		assert.Contains(t, resp, "access-control-max-age: 600\r\n")
+		assert.Contains(t, resp, "vary: Origin, Access-Control-Request-Method, Access-Control-Request-Headers\r\n")
+		assert.NotContains(t, resp, "\r\ncontent-length:")
+		assert.NotContains(t, resp, "\r\ncontent-type:")
+	})
+
+	t.Run("fail, preflight with not allowed method or header", func(t *testing.T) {
+		for _, lines := range [][]string{
+			{"Origin: https://app.example.com", "Access-Control-Request-Method: DELETE"},
+			{"Origin: https://app.example.com", "Access-Control-Request-Method: PUT", "Access-Control-Request-Headers: authorization"},
+			{"Origin: https://evil.com", "Access-Control-Request-Method: GET"},
+		} {
+			resp := serve(t, h, "OPTIONS", lines...)
+			assert.Contains(t, resp, "HTTP/1.1 204 No Content")
+			assert.NotContains(t, resp, "access-control-allow")
+		}
+	})
+
+	t.Run("ok, OPTIONS without request method is passed to handler", func(t *testing.T) {
+		called = false
+		serve(t, h, "OPTIONS", "Origin: https://app.example.com")
+		assert.True(t, called)
+	})
+
+	t.Run("ok, any origin without credentials", func(t *testing.T) {
+		anyOrigin := Middleware(Options{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}})(handler)
+
+		resp := serve(t, anyOrigin, "GET", "Origin: https://anyone.example")
+		assert.Contains(t, resp, "access-control-allow-origin: *\r\n")
+		assert.NotContains(t, resp, "access-control-allow-credentials")
+
+		resp = serve(t, anyOrigin, "OPTIONS", "Origin: https://anyone.example", "Access-Control-Request-Method: POST",
+			"Access-Control-Request-Headers: x-anything")
+		assert.Contains(t, resp, "access-control-allow-origin: *\r\n")
+		assert.Contains(t, resp, "access-control-allow-methods: GET, HEAD, POST\r\n")
+		assert.Contains(t, resp, "access-control-allow-headers: x-anything\r\n")
+	})
+	t.Run("ok, alternation pattern matches whole origin", func(t *testing.T) {
+		h := Middleware(Options{AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`https://a|https://a\.b`)}})(handler)
+
+		assert.Contains(t, serve(t, h, "GET", "Origin: https://a.b"), "access-control-allow-origin: https://a.b\r\n")
+		assert.NotContains(t, serve(t, h, "GET", "Origin: https://a.bc"), "access-control-allow-origin: https://a.bc")
+		assert.NotContains(t, serve(t, h, "GET", "Origin: https://evil.https://a"), "access-control-allow-origin: https://evil")
+	})
+
+	t.Run("fail, credentials for any origin", func(t *testing.T) {
+		opts := Options{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}
+		assert.Error(t, opts.Validate())
+		assert.Panics(t, func() { Middleware(opts) })
+	})
+}
@@ -0,0 +1,63 @@
+package fileserver
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/SSL0/http-impl/internal/compress"
+	"github.com/SSL0/http-impl/internal/conditional"
+	"github.com/SSL0/http-impl/internal/ranges"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func (f *FileServer) serveFile(w response.Writer, req *request.Request, name string, info os.FileInfo) error {
+	h := response.GetDefaultHeaders(int(info.Size()))
+	h.Change("Content-Type", contentType(name))
+
+	if f.opts.Precompressed {
+		h.Set("Vary", "Accept-Encoding")
+
+		acceptEncoding, _ := req.Headers.GetString("Accept-Encoding")
+		if compress.Negotiate(acceptEncoding, compress.Gzip) == compress.Gzip {
+			gzName, gzInfo, err := f.resolve(name + ".gz")
+			if err == nil && gzInfo.Mode().IsRegular() {
+				name, info = gzName, gzInfo
+				h.Change("Content-Length", strconv.FormatInt(info.Size(), 10))
+				h.Set("Content-Encoding", compress.Gzip)
+			}
+		}
+	}
+
+	etag := fileETag(info)
+	modTime := info.ModTime()
+	h.SetTime("Last-Modified", modTime)
+	h.Set("ETag", etag)
+
+	switch status := conditional.Evaluate(req, etag, modTime); status {
+	case response.StatusNotModified:
+		// it would be length of the representation, not of 304 itself,
+		// RFC 9110 section 8.6
+		h.Delete("Content-Length")
+		if err := w.WriteStatusLine(status); err != nil {
+			return err
+		}
+		return w.WriteHeaders(h)
+	case response.StatusPreconditionFailed:
+		return server.NewHandlerError(status, "")
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return ranges.ServeContent(w, req, h, file, info.Size())
+}
@@ -0,0 +1,204 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const defaultIndex = "index.html"
+
+type Options struct {
+	// Prefix is stripped from request path before looking up the file
+	Prefix string
+	Root   string
+	// Index is served for directories, index.html by default
+	Index string
+	// ListDirectories renders directories without index as HTML or JSON
+	ListDirectories bool
+	// SPAFallback serves root index for missing paths, so client side
+	// routing of single page applications works
+	SPAFallback bool
+	// Precompressed serves file.gz sibling with Content-Encoding: gzip
+	// when it exists and client accepts gzip
+	Precompressed bool
+}
+
+type FileServer struct {
+	opts Options
+	root string
+}
+
+func New(opts Options) (*FileServer, error) {
+	root, err := filepath.Abs(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("root is not a directory: %s", root)
+	}
+
+	if opts.Index == "" {
+		opts.Index = defaultIndex
+	}
+	opts.Prefix = "/" + strings.Trim(opts.Prefix, "/")
+
+	return &FileServer{opts: opts, root: root}, nil
+}
+
+func (f *FileServer) Handler() server.HandlerFunc {
+	return server.HandleContext(f.serve)
+}
+
+func (f *FileServer) serve(ctx context.Context, w response.Writer, req *request.Request) error {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		writeMethodNotAllowed(w, req)
+		return nil
+	}
+
+	urlPath, err := f.urlPath(req.RequestLine.RequestTarget)
+	if err != nil {
+		return err
+	}
+
+	name, info, err := f.open(urlPath)
+
+	if notExist(err) && f.opts.SPAFallback && path.Ext(urlPath) == "" {
+		urlPath = "/"
+		name, info, err = f.open(path.Join("/", f.opts.Index))
+	}
+
+	if notExist(err) {
+		return server.NewHandlerError(response.StatusNotFound, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			return writeRedirect(w, path.Join(f.opts.Prefix, urlPath)+"/")
+		}
+
+		indexName, indexInfo, err := f.open(path.Join(urlPath, f.opts.Index))
+		if err == nil && !indexInfo.IsDir() {
+			return f.serveFile(w, req, indexName, indexInfo)
+		}
+
+		if !f.opts.ListDirectories {
+			return server.NewHandlerError(response.StatusForbidden, "")
+		}
+
+		return f.serveListing(w, req, name, path.Join(f.opts.Prefix, urlPath))
+	}
+
+	return f.serveFile(w, req, name, info)
+}
+
+// urlPath returns cleaned request path without prefix, it always starts
+// with slash and keeps trailing one
+func (f *FileServer) urlPath(target string) (string, error) {
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return "", server.NewHandlerError(response.StatusBadRequset, "")
+	}
+
+	p := u.Path
+	if f.opts.Prefix != "/" {
+		if p != f.opts.Prefix && !strings.HasPrefix(p, f.opts.Prefix+"/") {
+			return "", server.NewHandlerError(response.StatusNotFound, "")
+		}
+		p = strings.TrimPrefix(p, f.opts.Prefix)
+	}
+
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", server.NewHandlerError(response.StatusBadRequset, "")
+	}
+
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned, nil
+}
+
+// open resolves url path under root. Symlinks pointing outside of root
+// are reported as missing files
+func (f *FileServer) open(urlPath string) (string, os.FileInfo, error) {
+	return f.resolve(filepath.Join(f.root, filepath.FromSlash(urlPath)))
+}
+
+func (f *FileServer) resolve(name string) (string, os.FileInfo, error) {
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	rel, err := filepath.Rel(f.root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, fs.ErrNotExist
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return resolved, info, nil
+}
+
+// notExist reports missing file, also when a file is used as directory
+// in the path like "/index.html/foo"
+func notExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
+}
+
+func contentType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func writeMethodNotAllowed(w response.Writer, req *request.Request) {
+	ow := response.Observe(w)
+	ow.OnHeaders(func(_ int, h headers.Headers) {
+		h.Set("Allow", "GET, HEAD")
+	})
+	server.RenderError(ow, req, server.NewHandlerError(response.StatusMethodNotAllowed, ""))
+}
+
+func writeRedirect(w response.Writer, location string) error {
+	h := response.GetDefaultHeaders(0)
+	h.Set("Location", location)
+
+	if err := w.WriteStatusLine(response.StatusMovedPermanently); err != nil {
+		return err
+	}
+	return w.WriteHeaders(h)
+}
@@ -0,0 +1,248 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/compress"
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRoot(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+
+	files := map[string]string{
+		"root/index.html":        "<h1>app</h1>",
+		"root/app.js":            "console.log(1)",
+		"root/style.css":         "body{}",
+		"root/data.bin":          "0123456789",
+		"root/docs/readme.txt":   "read me",
+		"root/assets/index.html": "assets index",
+		"secret.txt":             "top secret",
+	}
+	for name, content := range files {
+		p := filepath.Join(base, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "app.js"), filepath.Join(root, "inside.js")))
+
+	return root
+}
+
+func serve(t *testing.T, f *FileServer, method, target string, extraHeaders ...string) string {
+	t.Helper()
+	raw := method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, h := range extraHeaders {
+		raw += h + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	f.Handler()(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func headerValue(resp, name string) string {
+	for _, line := range strings.Split(resp, "\r\n") {
+		if v, ok := strings.CutPrefix(line, name+": "); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestFileServer(t *testing.T) {
+	root := setupRoot(t)
+	f, err := New(Options{Prefix: "/static", Root: root, ListDirectories: true})
+	require.NoError(t, err)
+
+	t.Run("ok, serve file with mime type", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/static/style.css")
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, resp, "content-type: text/css; charset=utf-8\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\nbody{}"))
+		assert.NotEmpty(t, headerValue(resp, "etag"))
+		assert.NotEmpty(t, headerValue(resp, "last-modified"))
+	})
+	t.Run("ok, head without body", func(t *testing.T) {
+		resp := serve(t, f, "HEAD", "/static/app.js")
+		assert.Contains(t, resp, "content-length: 14\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+	})
+	t.Run("ok, index for directory", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, f, "GET", "/static/"), "<h1>app</h1>"))
+		assert.True(t, strings.HasSuffix(serve(t, f, "GET", "/static/assets/"), "assets index"))
+	})
+	t.Run("ok, redirect directory without slash", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/static/docs")
+		assert.Contains(t, resp, "HTTP/1.1 301 Moved Permanently\r\n")
+		assert.Contains(t, resp, "location: /static/docs/\r\n")
+	})
+	t.Run("ok, directory listing", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/static/docs/")
+		assert.Contains(t, resp, "content-type: text/html\r\n")
+		assert.Contains(t, resp, `<a href="/static/docs/readme.txt">readme.txt</a>`)
+
+		resp = serve(t, f, "GET", "/static/docs/", "Accept: application/json")
+		assert.Contains(t, resp, "content-type: application/json\r\n")
+		assert.Contains(t, resp, `"name":"readme.txt","url":"/static/docs/readme.txt","isDir":false,"size":7`)
+
+		resp = serve(t, f, "GET", "/static/docs/", "Accept: text/html;q=0.5, application/*")
+		assert.Contains(t, resp, "content-type: application/json\r\n")
+	})
+	t.Run("ok, listing links are escaped", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a b#1?%.txt"), nil, 0o644))
+		defer os.Remove(filepath.Join(root, "docs", "a b#1?%.txt"))
+
+		resp := serve(t, f, "GET", "/static/docs/")
+		assert.Contains(t, resp, `<a href="/static/docs/a%20b%231%3F%25.txt">a b#1?%.txt</a>`)
+	})
+	t.Run("fail, listing not acceptable", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/static/docs/", "Accept: image/png")
+		assert.Contains(t, resp, "HTTP/1.1 406 Not Acceptable\r\n")
+	})
+	t.Run("ok, listing disabled", func(t *testing.T) {
+		f, err := New(Options{Root: root})
+		require.NoError(t, err)
+		assert.Contains(t, serve(t, f, "GET", "/docs/"), "HTTP/1.1 403 Forbidden\r\n")
+	})
+	t.Run("fail, path traversal", func(t *testing.T) {
+		for _, target := range []string{
+			"/static/../secret.txt",
+			"/static/%2e%2e/secret.txt",
+			"/static/docs/../../secret.txt",
+			"/static/..%2fsecret.txt",
+		} {
+			resp := serve(t, f, "GET", target)
+			assert.NotContains(t, resp, "top secret", target)
+		}
+	})
+	t.Run("fail, file used as directory", func(t *testing.T) {
+		assert.Contains(t, serve(t, f, "GET", "/static/app.js/foo"), "HTTP/1.1 404 Not Found\r\n")
+	})
+	t.Run("fail, symlink escape", func(t *testing.T) {
+		assert.Contains(t, serve(t, f, "GET", "/static/escape.txt"), "HTTP/1.1 404 Not Found\r\n")
+		assert.Contains(t, serve(t, f, "GET", "/static/inside.js"), "console.log(1)")
+	})
+	t.Run("fail, other prefix or method", func(t *testing.T) {
+		assert.Contains(t, serve(t, f, "GET", "/other/app.js"), "HTTP/1.1 404 Not Found\r\n")
+		assert.Contains(t, serve(t, f, "GET", "/staticapp.js"), "HTTP/1.1 404 Not Found\r\n")
+
+		resp := serve(t, f, "POST", "/static/app.js")
+		assert.Contains(t, resp, "HTTP/1.1 405 Method Not Allowed\r\n")
+		assert.Contains(t, resp, "allow: GET, HEAD\r\n")
+	})
+	t.Run("ok, conditional get", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/static/app.js")
+		etag := headerValue(resp, "etag")
+		lastModified := headerValue(resp, "last-modified")
+
+		resp = serve(t, f, "GET", "/static/app.js", "If-None-Match: "+etag)
+		assert.Contains(t, resp, "HTTP/1.1 304 Not Modified\r\n")
+		assert.NotContains(t, resp, "content-length")
+		assert.Equal(t, etag, headerValue(resp, "etag"))
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+		resp = serve(t, f, "GET", "/static/app.js", `If-None-Match: "other", W/`+etag)
+		assert.Contains(t, resp, "HTTP/1.1 304 Not Modified\r\n")
+
+		resp = serve(t, f, "GET", "/static/app.js", "If-Modified-Since: "+lastModified)
+		assert.Contains(t, resp, "HTTP/1.1 304 Not Modified\r\n")
+
+		past := headers.FormatTime(time.Now().Add(-48 * time.Hour))
+		resp = serve(t, f, "GET", "/static/app.js", "If-Modified-Since: "+past)
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+
+		// If-None-Match takes precedence
+		resp = serve(t, f, "GET", "/static/app.js", `If-None-Match: "other"`, "If-Modified-Since: "+lastModified)
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+	})
+	t.Run("ok, ranges", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/static/data.bin", "Range: bytes=2-4")
+		assert.Contains(t, resp, "HTTP/1.1 206 Partial Content\r\n")
+		assert.Contains(t, resp, "content-range: bytes 2-4/10\r\n")
+		assert.Contains(t, resp, "content-length: 3\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n234"))
+
+		resp = serve(t, f, "GET", "/static/data.bin", "Range: bytes=7-")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n789"))
+
+		resp = serve(t, f, "GET", "/static/data.bin", "Range: bytes=-2")
+		assert.Contains(t, resp, "content-range: bytes 8-9/10\r\n")
+
+		resp = serve(t, f, "GET", "/static/data.bin", "Range: bytes=20-")
+		assert.Contains(t, resp, "HTTP/1.1 416 Range Not Satisfiable\r\n")
+		assert.Contains(t, resp, "content-range: bytes */10\r\n")
+
+		resp = serve(t, f, "GET", "/static/data.bin", "Range: bytes=2-4", `If-Range: "stale"`)
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+	})
+	t.Run("ok, spa fallback", func(t *testing.T) {
+		f, err := New(Options{Root: root, SPAFallback: true})
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasSuffix(serve(t, f, "GET", "/users/42"), "<h1>app</h1>"))
+		assert.Contains(t, serve(t, f, "GET", "/missing.js"), "HTTP/1.1 404 Not Found\r\n")
+		assert.True(t, strings.HasSuffix(serve(t, f, "GET", "/index.html/users"), "<h1>app</h1>"))
+	})
+}
+
+func TestFileServerPreconditions(t *testing.T) {
+	f, err := New(Options{Root: setupRoot(t)})
+	require.NoError(t, err)
+
+	etag := headerValue(serve(t, f, "GET", "/app.js"), "etag")
+
+	assert.Contains(t, serve(t, f, "GET", "/app.js", "If-Match: "+etag), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, serve(t, f, "GET", "/app.js", `If-Match: "stale"`), "HTTP/1.1 412 Precondition Failed\r\n")
+}
+
+func TestFileServerPrecompressed(t *testing.T) {
+	root := setupRoot(t)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "app.js.gz"), []byte("gzipped js"), 0o644))
+
+	f, err := New(Options{Root: root, Precompressed: true})
+	require.NoError(t, err)
+
+	t.Run("ok, gz sibling served", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/app.js", "Accept-Encoding: gzip, deflate")
+		assert.Equal(t, "gzip", headerValue(resp, "content-encoding"))
+		assert.Equal(t, "Accept-Encoding", headerValue(resp, "vary"))
+		assert.Contains(t, headerValue(resp, "content-type"), "javascript")
+		assert.Equal(t, "10", headerValue(resp, "content-length"))
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\ngzipped js"))
+	})
+	t.Run("ok, plain file without gzip", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/app.js", "Accept-Encoding: gzip;q=0")
+		assert.Empty(t, headerValue(resp, "content-encoding"))
+		assert.Equal(t, "Accept-Encoding", headerValue(resp, "vary"))
+		assert.True(t, strings.HasSuffix(resp, "console.log(1)"))
+	})
+	t.Run("ok, vary once with compress middleware", func(t *testing.T) {
+		req, err := request.RequestFromReader(strings.NewReader("GET /style.css HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		compress.Middleware(compress.Options{})(f.Handler())(response.NewWriter(buf), req)
+		assert.Equal(t, "Accept-Encoding", headerValue(buf.String(), "vary"))
+	})
+	t.Run("ok, no sibling", func(t *testing.T) {
+		resp := serve(t, f, "GET", "/style.css", "Accept-Encoding: gzip")
+		assert.Empty(t, headerValue(resp, "content-encoding"))
+		assert.True(t, strings.HasSuffix(resp, "body{}"))
+	})
+}
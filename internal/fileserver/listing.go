@@ -0,0 +1,110 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+type entry struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<html>
+  <head>
+    <title>Index of {{.Path}}</title>
+  </head>
+  <body>
+    <h1>Index of {{.Path}}</h1>
+    <ul>
+{{- range .Entries}}
+      <li><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></li>
+{{- end}}
+    </ul>
+  </body>
+</html>
+`))
+
+func (f *FileServer) serveListing(w response.Writer, req *request.Request, dir, urlPath string) error {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	entries := []entry{}
+	for _, de := range dirEntries {
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+
+		e := entry{
+			Name:    de.Name(),
+			URL:     escapePath(path.Join(urlPath, de.Name())),
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		}
+		if e.IsDir {
+			e.URL += "/"
+		}
+		entries = append(entries, e)
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	contentType, err := req.Headers.Negotiate("text/html", "application/json")
+	if err != nil {
+		return server.NewHandlerError(response.StatusNotAcceptable, "")
+	}
+
+	body := &bytes.Buffer{}
+	if contentType == "application/json" {
+		err = json.NewEncoder(body).Encode(entries)
+	} else {
+		err = listingTemplate.Execute(body, map[string]any{"Path": urlPath, "Entries": entries})
+	}
+	if err != nil {
+		return err
+	}
+
+	h := response.GetDefaultHeaders(body.Len())
+	h.Change("Content-Type", contentType)
+
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+	return w.WriteBody(body.Bytes())
+}
+
+// escapePath escapes every segment, so names with "#", "?" or "%" are
+// not taken for other parts of URL
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,332 @@
+package headers
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TimeFormat is IMF-fixdate, the preferred HTTP date format, RFC 9110
+// section 5.6.7
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete formats recipients still have to accept
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// ParseTime parses HTTP date in IMF-fixdate, RFC 850 or asctime format
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid http date: %q", s)
+}
+
+// FormatTime formats t as IMF-fixdate
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// GetTime returns date field value, false if it is missing or malformed
+func (h *Headers) GetTime(key string) (time.Time, bool) {
+	v, ok := h.GetString(key)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := ParseTime(v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// SetTime replaces value of the field with t in IMF-fixdate
+func (h *Headers) SetTime(key string, t time.Time) {
+	h.Delete(key)
+	h.Set(key, FormatTime(t))
+}
+
+// SplitList splits comma separated list, commas inside of quoted strings
+// are kept. Elements are trimmed and empty ones are skipped
+func SplitList(s string) []string {
+	list := []string{}
+
+	for _, element := range splitQuoted(s, ',') {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+func (h *Headers) GetList(key string) []string {
+	v, _ := h.GetString(key)
+	return SplitList(v)
+}
+
+// HasToken reports whether list field contains token case insensitively,
+// e.g. "close" in Connection
+func (h *Headers) HasToken(key, token string) bool {
+	return slices.ContainsFunc(h.GetList(key), func(v string) bool {
+		return strings.EqualFold(v, token)
+	})
+}
+
+// GetDirectives parses list of name[=value] directives like Cache-Control.
+// Names are lowercased, values are unquoted
+func (h *Headers) GetDirectives(key string) map[string]string {
+	directives := map[string]string{}
+
+	for _, element := range h.GetList(key) {
+		name, value, _ := strings.Cut(element, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = Unquote(strings.TrimSpace(value))
+	}
+	return directives
+}
+
+// Element is a token followed by parameters, e.g. "text/html;
+// charset=utf-8" or "attachment; filename=report.pdf". Token is empty
+// for elements consisting only of parameters as in Forwarded
+type Element struct {
+	// Token is lowercased
+	Token string
+	// Params have lowercased names and unquoted values. RFC 8187 extended
+	// values like filename* are decoded and stored without the asterisk
+	Params map[string]string
+}
+
+// ParseElement parses token and semicolon separated parameters
+func ParseElement(s string) (Element, error) {
+	parts := splitQuoted(s, ';')
+	e := Element{Params: map[string]string{}}
+
+	first := strings.TrimSpace(parts[0])
+	if !strings.Contains(first, "=") {
+		if first == "" || !isToken(strings.ReplaceAll(first, "/", "")) {
+			return Element{}, fmt.Errorf("invalid token: %q", first)
+		}
+		e.Token = strings.ToLower(first)
+		parts = parts[1:]
+	}
+
+	extended := map[string]string{}
+
+	for _, param := range parts {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		if !ok || name == "" || !isToken(name) {
+			return Element{}, fmt.Errorf("invalid parameter: %q", param)
+		}
+
+		if base, ok := strings.CutSuffix(name, "*"); ok {
+			decoded, err := decodeExtValue(value)
+			if err != nil {
+				return Element{}, err
+			}
+			extended[base] = decoded
+			continue
+		}
+
+		if !isQuoted(value) && !isToken(value) {
+			return Element{}, fmt.Errorf("invalid parameter value: %q", param)
+		}
+		e.Params[name] = Unquote(value)
+	}
+
+	// extended values are preferred, RFC 6266 section 4.3
+	for name, value := range extended {
+		e.Params[name] = value
+	}
+
+	return e, nil
+}
+
+// String formats element with parameters sorted by name, values are
+// quoted when needed and non ASCII ones use RFC 8187 encoding
+func (e Element) String() string {
+	b := strings.Builder{}
+	b.WriteString(e.Token)
+
+	names := make([]string, 0, len(e.Params))
+	for name := range e.Params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	// Forwarded doesn't allow whitespace around semicolons
+	sep := "; "
+	if e.Token == "" {
+		sep = ";"
+	}
+
+	for _, name := range names {
+		if b.Len() > 0 {
+			b.WriteString(sep)
+		}
+
+		value := e.Params[name]
+		if isASCII(value) {
+			b.WriteString(name + "=" + QuoteString(value))
+		} else {
+			b.WriteString(name + "*=UTF-8''" + encodeExtValue(value))
+		}
+	}
+
+	return b.String()
+}
+
+// GetElement parses single element field like Content-Type or
+// Content-Disposition, false if it is missing or malformed
+func (h *Headers) GetElement(key string) (Element, bool) {
+	v, ok := h.GetString(key)
+	if !ok {
+		return Element{}, false
+	}
+
+	e, err := ParseElement(v)
+	if err != nil {
+		return Element{}, false
+	}
+	return e, true
+}
+
+// GetElements parses list of elements like Forwarded, malformed ones are
+// skipped
+func (h *Headers) GetElements(key string) []Element {
+	elements := []Element{}
+
+	for _, v := range h.GetList(key) {
+		if e, err := ParseElement(v); err == nil {
+			elements = append(elements, e)
+		}
+	}
+	return elements
+}
+
+// QuoteString returns s as is if it is a token, otherwise as quoted-string
+func QuoteString(s string) string {
+	if s != "" && isToken(s) {
+		return s
+	}
+
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// decodeExtValue decodes charset'language'value-chars, RFC 8187 section
+// 3.2. Only UTF-8 and ISO-8859-1 charsets are supported
+func decodeExtValue(s string) (string, error) {
+	parts := strings.SplitN(s, "'", 3)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid extended value: %q", s)
+	}
+
+	value, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid extended value: %q", s)
+	}
+
+	switch strings.ToLower(parts[0]) {
+	case "utf-8":
+		if !utf8.ValidString(value) {
+			return "", fmt.Errorf("invalid utf-8 in extended value: %q", s)
+		}
+		return value, nil
+	case "iso-8859-1":
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+		}
+		return string(runes), nil
+	}
+
+	return "", fmt.Errorf("unsupported charset in extended value: %q", s)
+}
+
+// encodeExtValue percent-encodes everything except attr-char
+func encodeExtValue(s string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < utf8.RuneSelf && c != '*' && c != '\'' && c != '%' && isToken(string(c)) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// splitQuoted splits s by sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	parts := []string{}
+	inQuotes := false
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case inQuotes && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"'
+}
+
+// Unquote removes quotes and escaping of quoted-string, other values are
+// returned as is
+func Unquote(s string) string {
+	if !isQuoted(s) {
+		return s
+	}
+
+	b := strings.Builder{}
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
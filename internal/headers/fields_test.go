@@ -0,0 +1,127 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+
+	t.Run("ok, all formats", func(t *testing.T) {
+		for _, s := range []string{
+			"Sun, 06 Nov 1994 08:49:37 GMT",
+			"Sunday, 06-Nov-94 08:49:37 GMT",
+			"Sun Nov  6 08:49:37 1994",
+		} {
+			got, err := ParseTime(s)
+			require.NoError(t, err, s)
+			assert.True(t, want.Equal(got), s)
+		}
+	})
+	t.Run("ok, format and accessors", func(t *testing.T) {
+		moscow := time.FixedZone("MSK", 3*60*60)
+		assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(want.In(moscow)))
+
+		h := NewHeaders()
+		h.Set("Last-Modified", "yesterday")
+		_, ok := h.GetTime("Last-Modified")
+		assert.False(t, ok)
+
+		h.SetTime("Last-Modified", want)
+		got, ok := h.GetTime("Last-Modified")
+		require.True(t, ok)
+		assert.True(t, want.Equal(got))
+		assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", h["last-modified"])
+	})
+	t.Run("fail, invalid dates", func(t *testing.T) {
+		for _, s := range []string{"", "06 Nov 1994", "Sun, 06 Nov 1994 08:49:37 UTC", "1994-11-06T08:49:37Z"} {
+			_, err := ParseTime(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
+
+func TestLists(t *testing.T) {
+	assert.Equal(t, []string{`"a,b"`, `W/"c"`, "d"}, SplitList(` "a,b" ,W/"c",, d `))
+	assert.Equal(t, []string{`"esc\",aped"`, "x"}, SplitList(`"esc\",aped", x`))
+	assert.Equal(t, []string{}, SplitList(""))
+
+	h := NewHeaders()
+	h.Set("Connection", "keep-alive, Upgrade")
+	assert.True(t, h.HasToken("Connection", "upgrade"))
+	assert.False(t, h.HasToken("Connection", "close"))
+
+	h.Set("Cache-Control", `max-age=60, no-cache="Set-Cookie, Vary", Private`)
+	assert.Equal(t, map[string]string{
+		"max-age":  "60",
+		"no-cache": "Set-Cookie, Vary",
+		"private":  "",
+	}, h.GetDirectives("Cache-Control"))
+}
+
+func TestElement(t *testing.T) {
+	t.Run("ok, content type", func(t *testing.T) {
+		e, err := ParseElement(`Text/HTML; Charset="utf-8" ;level=1`)
+		require.NoError(t, err)
+		assert.Equal(t, Element{Token: "text/html", Params: map[string]string{"charset": "utf-8", "level": "1"}}, e)
+		assert.Equal(t, "text/html; charset=utf-8; level=1", e.String())
+	})
+	t.Run("ok, content disposition", func(t *testing.T) {
+		e, err := ParseElement(`attachment; filename="EURO rates.txt"; filename*=UTF-8''%e2%82%ac%20rates.txt`)
+		require.NoError(t, err)
+		assert.Equal(t, "attachment", e.Token)
+		assert.Equal(t, "€ rates.txt", e.Params["filename"])
+
+		e, err = ParseElement(`attachment; filename*=iso-8859-1'en'%A3%20rates`)
+		require.NoError(t, err)
+		assert.Equal(t, "£ rates", e.Params["filename"])
+
+		e = Element{Token: "attachment", Params: map[string]string{"filename": "отчёт 1.pdf"}}
+		assert.Equal(t, "attachment; filename*=UTF-8''%D0%BE%D1%82%D1%87%D1%91%D1%82%201.pdf", e.String())
+		parsed, err := ParseElement(e.String())
+		require.NoError(t, err)
+		assert.Equal(t, e, parsed)
+
+		e = Element{Token: "inline", Params: map[string]string{"filename": `my "file".txt`}}
+		assert.Equal(t, `inline; filename="my \"file\".txt"`, e.String())
+	})
+	t.Run("ok, forwarded", func(t *testing.T) {
+		h := NewHeaders()
+		h.Set("Forwarded", `for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`)
+
+		elements := h.GetElements("Forwarded")
+		require.Len(t, elements, 2)
+		assert.Equal(t, Element{Params: map[string]string{"for": "192.0.2.60", "proto": "http", "by": "203.0.113.43"}}, elements[0])
+		assert.Equal(t, "[2001:db8:cafe::17]:4711", elements[1].Params["for"])
+		assert.Equal(t, `for="[2001:db8:cafe::17]:4711"`, elements[1].String())
+		assert.Equal(t, "by=203.0.113.43;for=192.0.2.60;proto=http", elements[0].String())
+	})
+	t.Run("ok, accessor", func(t *testing.T) {
+		h := NewHeaders()
+		h.Set("Content-Type", "multipart/form-data; boundary=----abc")
+		e, ok := h.GetElement("Content-Type")
+		require.True(t, ok)
+		assert.Equal(t, "----abc", e.Params["boundary"])
+
+		_, ok = h.GetElement("Content-Disposition")
+		assert.False(t, ok)
+	})
+	t.Run("fail, malformed", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			"text html",
+			"text/html; charset",
+			"text/html; =utf-8",
+			"text/html; charset=utf 8",
+			"attachment; filename*=%e2%82%ac",
+			"attachment; filename*=koi8-r''%c1",
+		} {
+			_, err := ParseElement(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
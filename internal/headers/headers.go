@@ -10,8 +10,17 @@ import (
 const (
 	CRLF                   = "\r\n"
 	fieldValueInvalidChars = "\r\n\x00"
+	// fieldLineSep separates values of fields which can't be combined into
+	// a comma separated list. It can't appear in a field value
+	fieldLineSep = "\n"
 )
 
+// Set-Cookie values contain commas in Expires and must be sent as separate
+// field lines, RFC 9110 section 5.3
+var separateLines = map[string]bool{
+	"set-cookie": true,
+}
+
 type Headers map[string]string
 
 func NewHeaders() Headers {
@@ -43,12 +52,26 @@ func (h *Headers) Set(key, value string) {
 	key = strings.ToLower(key)
 
 	if v, ok := (*h)[key]; ok {
-		(*h)[key] = fmt.Sprintf("%s, %s", v, value)
+		if separateLines[key] {
+			(*h)[key] = v + fieldLineSep + value
+		} else {
+			(*h)[key] = fmt.Sprintf("%s, %s", v, value)
+		}
 	} else {
 		(*h)[key] = value
 	}
 }
 
+// Values returns values of field lines, only fields like Set-Cookie can
+// have more than one
+func (h *Headers) Values(key string) []string {
+	v, ok := (*h)[strings.ToLower(key)]
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, fieldLineSep)
+}
+
 func (h *Headers) Change(key, newValue string) {
 	key = strings.ToLower(key)
 
@@ -58,9 +81,16 @@ func (h *Headers) Change(key, newValue string) {
 	}
 }
 
+func (h *Headers) Delete(key string) {
+	delete(*h, strings.ToLower(key))
+}
+
+// ForEach calls callback for every field line
 func (h *Headers) ForEach(callback func(k, v string)) {
 	for k, v := range *h {
-		callback(k, v)
+		for _, line := range strings.Split(v, fieldLineSep) {
+			callback(k, line)
+		}
 	}
 }
 
@@ -125,6 +155,11 @@ func parseFieldLine(fieldLine []byte) (string, string, error) {
 	return key, value, nil
 }
 
+// IsToken reports whether s is a non-empty token, RFC 9110 section 5.6.2
+func IsToken(s string) bool {
+	return s != "" && isToken(s)
+}
+
 func isToken(s string) bool {
 	for _, ch := range s {
 		valid := false
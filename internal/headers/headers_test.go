@@ -95,3 +95,25 @@ func TestHeadersParse(t *testing.T) {
 		assert.False(t, done)
 	})
 }
+
+func TestSeparateFieldLines(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Set-Cookie", "a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT")
+	h.Set("Set-Cookie", "b=2")
+	h.Set("Vary", "Accept")
+	h.Set("Vary", "Origin")
+
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT", "b=2"}, h.Values("Set-Cookie"))
+	assert.Equal(t, []string{"Accept, Origin"}, h.Values("Vary"))
+	assert.Nil(t, h.Values("Cookie"))
+
+	lines := []string{}
+	h.ForEach(func(k, v string) {
+		lines = append(lines, k+": "+v)
+	})
+	assert.ElementsMatch(t, []string{
+		"set-cookie: a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT",
+		"set-cookie: b=2",
+		"vary: Accept, Origin",
+	}, lines)
+}
@@ -0,0 +1,226 @@
+package headers
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrNotAcceptable means none of the offers is acceptable for client, the
+// response should be 406 Not Acceptable
+var ErrNotAcceptable = errors.New("no acceptable offer")
+
+// Preference is an element of Accept, Accept-Charset, Accept-Encoding or
+// Accept-Language field, RFC 9110 section 12.5
+type Preference struct {
+	// Value is lowercased media range, charset, coding or language range
+	Value string
+	// Params are media type parameters preceding q, names are lowercased
+	Params map[string]string
+	Q      float64
+}
+
+// ParsePreferences parses a preference list. Elements with malformed
+// q-value are dropped, the rest are sorted by q-value keeping field order
+// for equal ones
+func ParsePreferences(value string) []Preference {
+	prefs := []Preference{}
+
+	for _, element := range splitQuoted(value, ',') {
+		parts := splitQuoted(element, ';')
+		v := strings.ToLower(strings.TrimSpace(parts[0]))
+		if v == "" {
+			continue
+		}
+
+		pref := Preference{Value: v, Q: 1}
+		valid := true
+
+		for _, param := range parts[1:] {
+			k, pv, _ := strings.Cut(param, "=")
+			k = strings.ToLower(strings.TrimSpace(k))
+			pv = Unquote(strings.TrimSpace(pv))
+
+			// parameters after q are accept-ext, they don't affect matching
+			if k == "q" {
+				q, ok := parseQValue(pv)
+				if !ok {
+					valid = false
+				}
+				pref.Q = q
+				break
+			}
+
+			if pref.Params == nil {
+				pref.Params = map[string]string{}
+			}
+			pref.Params[k] = pv
+		}
+
+		if valid {
+			prefs = append(prefs, pref)
+		}
+	}
+
+	slices.SortStableFunc(prefs, func(a, b Preference) int {
+		switch {
+		case a.Q > b.Q:
+			return -1
+		case a.Q < b.Q:
+			return 1
+		}
+		return 0
+	})
+
+	return prefs
+}
+
+func (h *Headers) Accept() []Preference {
+	v, _ := h.GetString("Accept")
+	return ParsePreferences(v)
+}
+
+func (h *Headers) AcceptLanguage() []Preference {
+	v, _ := h.GetString("Accept-Language")
+	return ParsePreferences(v)
+}
+
+func (h *Headers) AcceptCharset() []Preference {
+	v, _ := h.GetString("Accept-Charset")
+	return ParsePreferences(v)
+}
+
+// Negotiate picks media type from offers by Accept field. Offers may have
+// parameters, e.g. "application/vnd.api+json; version=2", a media range
+// with parameters matches only offers having the same ones. Earlier offers
+// win ties, the first one is returned when there is no Accept field
+func (h *Headers) Negotiate(offers ...string) (string, error) {
+	v, ok := h.GetString("Accept")
+	return negotiate(v, ok, offers, matchMediaType)
+}
+
+// NegotiateLanguage picks language tag from offers by Accept-Language
+// field using basic filtering, RFC 4647 section 3.3.1
+func (h *Headers) NegotiateLanguage(offers ...string) (string, error) {
+	v, ok := h.GetString("Accept-Language")
+	return negotiate(v, ok, offers, matchLanguage)
+}
+
+// NegotiateCharset picks charset from offers by Accept-Charset field
+func (h *Headers) NegotiateCharset(offers ...string) (string, error) {
+	v, ok := h.GetString("Accept-Charset")
+	return negotiate(v, ok, offers, matchCharset)
+}
+
+// matcher reports how specific pref is for offer, -1 if it doesn't match
+type matcher func(pref Preference, offer string) int
+
+func negotiate(value string, present bool, offers []string, match matcher) (string, error) {
+	if len(offers) == 0 {
+		return "", ErrNotAcceptable
+	}
+	// absent or empty field means any offer is acceptable
+	if !present || strings.TrimSpace(value) == "" {
+		return offers[0], nil
+	}
+
+	prefs := ParsePreferences(value)
+	best := ""
+	bestQ := 0.0
+
+	for _, offer := range offers {
+		q := 0.0
+		specificity := -1
+
+		// the most specific matching preference defines q-value of offer
+		for _, pref := range prefs {
+			if s := match(pref, offer); s > specificity {
+				specificity, q = s, pref.Q
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	if best == "" {
+		return "", ErrNotAcceptable
+	}
+	return best, nil
+}
+
+func matchMediaType(pref Preference, offer string) int {
+	parts := splitQuoted(offer, ';')
+	mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+	offerType, offerSubtype, _ := strings.Cut(mediaType, "/")
+	prefType, prefSubtype, _ := strings.Cut(pref.Value, "/")
+
+	specificity := 0
+	switch {
+	case pref.Value == "*/*":
+	case prefSubtype == "*" && prefType == offerType:
+		specificity = 1
+	case prefType == offerType && prefSubtype == offerSubtype:
+		specificity = 2
+	default:
+		return -1
+	}
+
+	if len(pref.Params) == 0 {
+		return specificity
+	}
+
+	offerParams := map[string]string{}
+	for _, param := range parts[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		offerParams[strings.ToLower(strings.TrimSpace(k))] = Unquote(strings.TrimSpace(v))
+	}
+	for k, v := range pref.Params {
+		if ov, ok := offerParams[k]; !ok || !strings.EqualFold(ov, v) {
+			return -1
+		}
+	}
+
+	return specificity + len(pref.Params)
+}
+
+func matchLanguage(pref Preference, offer string) int {
+	offer = strings.ToLower(offer)
+
+	switch {
+	case pref.Value == "*":
+		return 0
+	case offer == pref.Value || strings.HasPrefix(offer, pref.Value+"-"):
+		return len(pref.Value)
+	}
+	return -1
+}
+
+func matchCharset(pref Preference, offer string) int {
+	switch {
+	case pref.Value == "*":
+		return 0
+	case strings.EqualFold(offer, pref.Value):
+		return 1
+	}
+	return -1
+}
+
+// parseQValue accepts weight per RFC 9110 section 12.4.2: 0 to 1 with
+// up to three digits after the point
+func parseQValue(s string) (float64, bool) {
+	if s == "" || len(s) > 5 || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+	if len(s) > 1 && s[1] != '.' {
+		return 0, false
+	}
+
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
@@ -0,0 +1,103 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePreferences(t *testing.T) {
+	t.Run("ok, sorted by q-value", func(t *testing.T) {
+		prefs := ParsePreferences(`text/html;level=1, text/*;q=0.3, application/json;q=0.9;ext=1, */*;q=0.1`)
+		require.Len(t, prefs, 4)
+		assert.Equal(t, Preference{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 1}, prefs[0])
+		assert.Equal(t, Preference{Value: "application/json", Q: 0.9}, prefs[1])
+		assert.Equal(t, "text/*", prefs[2].Value)
+		assert.Equal(t, "*/*", prefs[3].Value)
+	})
+	t.Run("ok, quoted parameters", func(t *testing.T) {
+		prefs := ParsePreferences(`text/plain;format="a,b;c", text/html`)
+		require.Len(t, prefs, 2)
+		assert.Equal(t, map[string]string{"format": "a,b;c"}, prefs[0].Params)
+	})
+	t.Run("fail, malformed q-values are dropped", func(t *testing.T) {
+		prefs := ParsePreferences("a;q=2, b;q=0.1234, c;q=x, d;q=.5, e;q=0.5")
+		require.Len(t, prefs, 1)
+		assert.Equal(t, "e", prefs[0].Value)
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		{"", []string{"text/html", "application/json"}, "text/html"},
+		{"application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"text/*, application/json;q=0.5", []string{"application/json", "text/plain"}, "text/plain"},
+		{"*/*;q=0.1, application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"text/*;q=0.5, text/html;q=0", []string{"text/html", "text/plain"}, "text/plain"},
+		{"TEXT/HTML", []string{"text/html"}, "text/html"},
+		{"application/vnd.api+json;version=2", []string{"application/vnd.api+json; version=1", "application/vnd.api+json; version=2"}, "application/vnd.api+json; version=2"},
+		{"application/vnd.api+json", []string{"application/vnd.api+json; version=1", "application/vnd.api+json; version=2"}, "application/vnd.api+json; version=1"},
+		{"application/vnd.api+json;version=2;q=0.5, application/vnd.api+json;version=1;q=0.8", []string{"application/vnd.api+json; version=2", "application/vnd.api+json; version=1"}, "application/vnd.api+json; version=1"},
+	}
+
+	for _, c := range cases {
+		h := NewHeaders()
+		if c.accept != "" {
+			h.Set("Accept", c.accept)
+		}
+		got, err := h.Negotiate(c.offers...)
+		require.NoError(t, err, c.accept)
+		assert.Equal(t, c.want, got, c.accept)
+	}
+
+	t.Run("fail, not acceptable", func(t *testing.T) {
+		for _, accept := range []string{"image/png", "text/html;q=0", "application/vnd.api+json;version=3"} {
+			h := NewHeaders()
+			h.Set("Accept", accept)
+			_, err := h.Negotiate("text/html", "application/vnd.api+json; version=2")
+			assert.ErrorIs(t, err, ErrNotAcceptable, accept)
+		}
+	})
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Accept-Language", "ru-RU, en;q=0.8, *;q=0.1")
+
+	got, err := h.NegotiateLanguage("en-US", "ru")
+	require.NoError(t, err)
+	assert.Equal(t, "en-US", got, "ru-RU range doesn't match less specific ru tag")
+
+	got, err = h.NegotiateLanguage("de", "ru-ru")
+	require.NoError(t, err)
+	assert.Equal(t, "ru-ru", got)
+
+	got, err = h.NegotiateLanguage("de")
+	require.NoError(t, err)
+	assert.Equal(t, "de", got)
+
+	h.Change("Accept-Language", "fr")
+	_, err = h.NegotiateLanguage("de", "en")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
+
+func TestNegotiateCharset(t *testing.T) {
+	h := NewHeaders()
+	got, err := h.NegotiateCharset("utf-8")
+	require.NoError(t, err)
+	assert.Equal(t, "utf-8", got)
+
+	h.Set("Accept-Charset", "iso-8859-5, UTF-8;q=0.9")
+	got, err = h.NegotiateCharset("utf-8", "iso-8859-5")
+	require.NoError(t, err)
+	assert.Equal(t, "iso-8859-5", got)
+
+	h.Change("Accept-Charset", "koi8-r")
+	_, err = h.NegotiateCharset("utf-8")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
@@ -0,0 +1,852 @@
+package headers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Structured Field Values, RFC 9651. Bare items are represented by Go
+// types:
+//
+//	Integer        int64
+//	Decimal        float64
+//	String         string
+//	Token          Token
+//	Byte Sequence  []byte
+//	Boolean        bool
+//	Date           time.Time
+//	Display String DisplayString
+
+type Token string
+
+type DisplayString string
+
+// Param is a key with bare item value. Boolean true values are
+// serialized without value, e.g. ";secure"
+type Param struct {
+	Key   string
+	Value any
+}
+
+// Params keep order of parameters, keys are unique
+type Params []Param
+
+// Get returns value of the parameter with key
+func (p Params) Get(key string) (any, bool) {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// set overwrites value of existing key keeping its position
+func (p Params) set(key string, value any) Params {
+	for i := range p {
+		if p[i].Key == key {
+			p[i].Value = value
+			return p
+		}
+	}
+	return append(p, Param{Key: key, Value: value})
+}
+
+// Member is an Item or an InnerList
+type Member interface {
+	member()
+}
+
+type Item struct {
+	Value  any
+	Params Params
+}
+
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+func (Item) member()      {}
+func (InnerList) member() {}
+
+type StructuredList []Member
+
+type DictMember struct {
+	Key    string
+	Member Member
+}
+
+// Dictionary keeps order of members, keys are unique
+type Dictionary []DictMember
+
+// Get returns member with key
+func (d Dictionary) Get(key string) (Member, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Member, true
+		}
+	}
+	return nil, false
+}
+
+const (
+	maxInteger       = 999_999_999_999_999
+	maxIntegerDigits = 15
+	maxDecimalDigits = 16
+	maxDecimalInt    = 12
+	maxDecimalFrac   = 3
+)
+
+var ErrInvalidStructuredField = errors.New("invalid structured field")
+
+func structuredError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidStructuredField, fmt.Sprintf(format, args...))
+}
+
+// ParseItem parses Item field, multiple field lines are combined
+func ParseItem(fieldLines ...string) (Item, error) {
+	p := newSFParser(fieldLines)
+	item, err := p.parseItem()
+	if err != nil {
+		return Item{}, err
+	}
+	return item, p.finish()
+}
+
+// ParseStructuredList parses List field, multiple field lines are
+// combined
+func ParseStructuredList(fieldLines ...string) (StructuredList, error) {
+	p := newSFParser(fieldLines)
+	list := StructuredList{}
+
+	for !p.empty() {
+		member, err := p.parseItemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, member)
+
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, p.finish()
+}
+
+// ParseDictionary parses Dictionary field, multiple field lines are
+// combined
+func ParseDictionary(fieldLines ...string) (Dictionary, error) {
+	p := newSFParser(fieldLines)
+	dict := Dictionary{}
+
+	for !p.empty() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var member Member
+		if p.peek() == '=' {
+			p.pos++
+			if member, err = p.parseItemOrInnerList(); err != nil {
+				return nil, err
+			}
+		} else {
+			params, err := p.parseParams()
+			if err != nil {
+				return nil, err
+			}
+			member = Item{Value: true, Params: params}
+		}
+
+		dict = dict.set(key, member)
+
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+
+	return dict, p.finish()
+}
+
+func (d Dictionary) set(key string, member Member) Dictionary {
+	for i := range d {
+		if d[i].Key == key {
+			d[i].Member = member
+			return d
+		}
+	}
+	return append(d, DictMember{Key: key, Member: member})
+}
+
+func (h *Headers) GetItem(key string) (Item, bool) {
+	v, ok := h.GetString(key)
+	if !ok {
+		return Item{}, false
+	}
+	item, err := ParseItem(v)
+	return item, err == nil
+}
+
+func (h *Headers) GetStructuredList(key string) (StructuredList, bool) {
+	v, ok := h.GetString(key)
+	if !ok {
+		return nil, false
+	}
+	list, err := ParseStructuredList(v)
+	return list, err == nil
+}
+
+func (h *Headers) GetDictionary(key string) (Dictionary, bool) {
+	v, ok := h.GetString(key)
+	if !ok {
+		return nil, false
+	}
+	dict, err := ParseDictionary(v)
+	return dict, err == nil
+}
+
+type sfParser struct {
+	input string
+	pos   int
+}
+
+func newSFParser(fieldLines []string) *sfParser {
+	p := &sfParser{input: strings.Join(fieldLines, ", ")}
+	p.skipSP()
+	return p
+}
+
+func (p *sfParser) empty() bool {
+	return p.pos >= len(p.input)
+}
+
+// peek returns 0 at the end of input
+func (p *sfParser) peek() byte {
+	if p.empty() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *sfParser) skipSP() {
+	for p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.pos++
+	}
+}
+
+func (p *sfParser) finish() error {
+	p.skipSP()
+	if !p.empty() {
+		return structuredError("unexpected %q at %d", p.input[p.pos:], p.pos)
+	}
+	return nil
+}
+
+// nextMember consumes separator between list or dictionary members
+func (p *sfParser) nextMember() error {
+	p.skipOWS()
+	if p.empty() {
+		return nil
+	}
+	if p.peek() != ',' {
+		return structuredError("expected comma at %d", p.pos)
+	}
+	p.pos++
+	p.skipOWS()
+	if p.empty() {
+		return structuredError("trailing comma")
+	}
+	return nil
+}
+
+func (p *sfParser) parseItemOrInnerList() (Member, error) {
+	if p.peek() == '(' {
+		return p.parseInnerList()
+	}
+	return p.parseItem()
+}
+
+func (p *sfParser) parseInnerList() (InnerList, error) {
+	p.pos++
+	list := InnerList{Items: []Item{}}
+
+	for !p.empty() {
+		p.skipSP()
+
+		if p.peek() == ')' {
+			p.pos++
+			params, err := p.parseParams()
+			if err != nil {
+				return InnerList{}, err
+			}
+			list.Params = params
+			return list, nil
+		}
+
+		item, err := p.parseItem()
+		if err != nil {
+			return InnerList{}, err
+		}
+		list.Items = append(list.Items, item)
+
+		if c := p.peek(); c != ' ' && c != ')' {
+			return InnerList{}, structuredError("unexpected character in inner list at %d", p.pos)
+		}
+	}
+
+	return InnerList{}, structuredError("unterminated inner list")
+}
+
+func (p *sfParser) parseItem() (Item, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	params, err := p.parseParams()
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: value, Params: params}, nil
+}
+
+func (p *sfParser) parseParams() (Params, error) {
+	params := Params{}
+
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var value any = true
+		if p.peek() == '=' {
+			p.pos++
+			if value, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+
+		params = params.set(key, value)
+	}
+
+	return params, nil
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.pos
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", structuredError("invalid key at %d", p.pos)
+	}
+
+	for c := p.peek(); isKeyChar(c); c = p.peek() {
+		p.pos++
+	}
+	return p.input[start:p.pos], nil
+}
+
+func (p *sfParser) parseBareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == '*' || isAlpha(c):
+		return p.parseToken(), nil
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	case c == '@':
+		return p.parseDate()
+	case c == '%':
+		return p.parseDisplayString()
+	}
+	return nil, structuredError("unexpected %q at %d", p.input[p.pos:], p.pos)
+}
+
+// parseNumber returns int64 or float64
+func (p *sfParser) parseNumber() (any, error) {
+	sign := int64(1)
+	if p.peek() == '-' {
+		sign = -1
+		p.pos++
+	}
+	if !isDigit(p.peek()) {
+		return nil, structuredError("expected digit at %d", p.pos)
+	}
+
+	start := p.pos
+	decimal := false
+
+	for !p.empty() {
+		c := p.peek()
+		if c == '.' && !decimal {
+			if p.pos-start > maxDecimalInt {
+				return nil, structuredError("decimal integer component is too long")
+			}
+			decimal = true
+		} else if !isDigit(c) {
+			break
+		}
+		p.pos++
+
+		if !decimal && p.pos-start > maxIntegerDigits {
+			return nil, structuredError("integer is too long")
+		}
+		if decimal && p.pos-start > maxDecimalDigits {
+			return nil, structuredError("decimal is too long")
+		}
+	}
+
+	number := p.input[start:p.pos]
+
+	if !decimal {
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			return nil, structuredError("invalid integer %q", number)
+		}
+		return sign * n, nil
+	}
+
+	dot := strings.IndexByte(number, '.')
+	if frac := len(number) - dot - 1; frac == 0 || frac > maxDecimalFrac {
+		return nil, structuredError("invalid decimal fraction %q", number)
+	}
+
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return nil, structuredError("invalid decimal %q", number)
+	}
+	return float64(sign) * f, nil
+}
+
+func (p *sfParser) parseString() (string, error) {
+	p.pos++
+	b := strings.Builder{}
+
+	for !p.empty() {
+		c := p.input[p.pos]
+		p.pos++
+
+		switch {
+		case c == '\\':
+			if next := p.peek(); next != '"' && next != '\\' {
+				return "", structuredError("invalid escape in string at %d", p.pos)
+			}
+			b.WriteByte(p.input[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", structuredError("invalid character in string at %d", p.pos-1)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", structuredError("unterminated string")
+}
+
+func (p *sfParser) parseToken() Token {
+	start := p.pos
+	p.pos++
+	for c := p.peek(); c != 0 && (isToken(string(c)) || c == ':' || c == '/'); c = p.peek() {
+		p.pos++
+	}
+	return Token(p.input[start:p.pos])
+}
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	p.pos++
+	end := strings.IndexByte(p.input[p.pos:], ':')
+	if end == -1 {
+		return nil, structuredError("unterminated byte sequence")
+	}
+
+	encoded := p.input[p.pos : p.pos+end]
+	p.pos += end + 1
+
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '/' && c != '=' {
+			return nil, structuredError("invalid character in byte sequence")
+		}
+	}
+
+	// missing padding is tolerated, RFC 9651 section 4.2.7
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, structuredError("invalid byte sequence: %v", err)
+	}
+	return decoded, nil
+}
+
+func (p *sfParser) parseBoolean() (bool, error) {
+	p.pos++
+	switch p.peek() {
+	case '1':
+		p.pos++
+		return true, nil
+	case '0':
+		p.pos++
+		return false, nil
+	}
+	return false, structuredError("invalid boolean at %d", p.pos)
+}
+
+func (p *sfParser) parseDate() (time.Time, error) {
+	p.pos++
+	n, err := p.parseNumber()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	seconds, ok := n.(int64)
+	if !ok {
+		return time.Time{}, structuredError("date must be an integer")
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+func (p *sfParser) parseDisplayString() (DisplayString, error) {
+	p.pos++
+	if p.peek() != '"' {
+		return "", structuredError("expected quote after %% at %d", p.pos)
+	}
+	p.pos++
+
+	b := []byte{}
+
+	for !p.empty() {
+		c := p.input[p.pos]
+		p.pos++
+
+		switch {
+		case c < 0x20 || c > 0x7e:
+			return "", structuredError("invalid character in display string at %d", p.pos-1)
+		case c == '%':
+			if p.pos+2 > len(p.input) || !isLowerHex(p.input[p.pos]) || !isLowerHex(p.input[p.pos+1]) {
+				return "", structuredError("invalid percent encoding at %d", p.pos)
+			}
+			v, _ := strconv.ParseUint(p.input[p.pos:p.pos+2], 16, 8)
+			b = append(b, byte(v))
+			p.pos += 2
+		case c == '"':
+			if !utf8.Valid(b) {
+				return "", structuredError("invalid utf-8 in display string")
+			}
+			return DisplayString(b), nil
+		default:
+			b = append(b, c)
+		}
+	}
+
+	return "", structuredError("unterminated display string")
+}
+
+// SerializeItem returns canonical form of item
+func SerializeItem(item Item) (string, error) {
+	b := &strings.Builder{}
+	if err := writeItem(b, item); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// SerializeList returns canonical form of list, empty list means the
+// field should not be sent
+func SerializeList(list StructuredList) (string, error) {
+	b := &strings.Builder{}
+
+	for i, member := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeMember(b, member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// SerializeDictionary returns canonical form of dictionary, empty
+// dictionary means the field should not be sent
+func SerializeDictionary(dict Dictionary) (string, error) {
+	b := &strings.Builder{}
+
+	for i, m := range dict {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeKey(b, m.Key); err != nil {
+			return "", err
+		}
+
+		if item, ok := m.Member.(Item); ok && item.Value == true {
+			if err := writeParams(b, item.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		b.WriteByte('=')
+		if err := writeMember(b, m.Member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func (h *Headers) SetItem(key string, item Item) error {
+	v, err := SerializeItem(item)
+	if err != nil {
+		return err
+	}
+	h.Delete(key)
+	h.Set(key, v)
+	return nil
+}
+
+func (h *Headers) SetStructuredList(key string, list StructuredList) error {
+	v, err := SerializeList(list)
+	if err != nil {
+		return err
+	}
+	h.Delete(key)
+	if v != "" {
+		h.Set(key, v)
+	}
+	return nil
+}
+
+func (h *Headers) SetDictionary(key string, dict Dictionary) error {
+	v, err := SerializeDictionary(dict)
+	if err != nil {
+		return err
+	}
+	h.Delete(key)
+	if v != "" {
+		h.Set(key, v)
+	}
+	return nil
+}
+
+func writeMember(b *strings.Builder, member Member) error {
+	switch m := member.(type) {
+	case Item:
+		return writeItem(b, m)
+	case InnerList:
+		b.WriteByte('(')
+		for i, item := range m.Items {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			if err := writeItem(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+		return writeParams(b, m.Params)
+	}
+	return structuredError("unknown member type %T", member)
+}
+
+func writeItem(b *strings.Builder, item Item) error {
+	if err := writeBareItem(b, item.Value); err != nil {
+		return err
+	}
+	return writeParams(b, item.Params)
+}
+
+func writeParams(b *strings.Builder, params Params) error {
+	for _, param := range params {
+		b.WriteByte(';')
+		if err := writeKey(b, param.Key); err != nil {
+			return err
+		}
+		if param.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeBareItem(b, param.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeKey(b *strings.Builder, key string) error {
+	if key == "" || (!isLCAlpha(key[0]) && key[0] != '*') {
+		return structuredError("invalid key %q", key)
+	}
+	for i := 0; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return structuredError("invalid key %q", key)
+		}
+	}
+	b.WriteString(key)
+	return nil
+}
+
+func writeBareItem(b *strings.Builder, value any) error {
+	switch v := value.(type) {
+	case int:
+		return writeInteger(b, int64(v))
+	case int64:
+		return writeInteger(b, v)
+	case float64:
+		return writeDecimal(b, v)
+	case string:
+		return writeString(b, v)
+	case Token:
+		return writeToken(b, v)
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	case time.Time:
+		b.WriteByte('@')
+		return writeInteger(b, v.Unix())
+	case DisplayString:
+		return writeDisplayString(b, v)
+	default:
+		return structuredError("unsupported bare item type %T", value)
+	}
+	return nil
+}
+
+func writeInteger(b *strings.Builder, v int64) error {
+	if v < -maxInteger || v > maxInteger {
+		return structuredError("integer %d is out of range", v)
+	}
+	b.WriteString(strconv.FormatInt(v, 10))
+	return nil
+}
+
+// writeDecimal rounds to three fractional digits half to even. Rounding
+// is done on the shortest decimal representation so values like 0.0025
+// aren't affected by binary floating point error
+func writeDecimal(b *strings.Builder, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return structuredError("decimal %v is out of range", v)
+	}
+
+	intPart, frac, _ := strings.Cut(strconv.FormatFloat(math.Abs(v), 'f', -1, 64), ".")
+	if len(intPart) > maxDecimalInt {
+		return structuredError("decimal %v is out of range", v)
+	}
+
+	// padding guarantees the digit deciding rounding exists
+	frac += "0000"
+	n, _ := strconv.ParseInt(intPart+frac[:maxDecimalFrac], 10, 64)
+
+	if rest := frac[maxDecimalFrac:]; rest[0] > '5' ||
+		(rest[0] == '5' && (strings.Trim(rest[1:], "0") != "" || n%2 == 1)) {
+		n++
+	}
+	if n >= 1e15 {
+		return structuredError("decimal %v is out of range", v)
+	}
+
+	if v < 0 {
+		b.WriteByte('-')
+	}
+
+	fracDigits := strings.TrimRight(fmt.Sprintf("%03d", n%1000), "0")
+	if fracDigits == "" {
+		fracDigits = "0"
+	}
+	b.WriteString(strconv.FormatInt(n/1000, 10) + "." + fracDigits)
+	return nil
+}
+
+func writeString(b *strings.Builder, v string) error {
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x20 || c > 0x7e {
+			return structuredError("invalid character in string %q", v)
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return nil
+}
+
+func writeToken(b *strings.Builder, v Token) error {
+	if v == "" || (v[0] != '*' && !isAlpha(v[0])) {
+		return structuredError("invalid token %q", v)
+	}
+	for i := 1; i < len(v); i++ {
+		if c := v[i]; !isToken(string(c)) && c != ':' && c != '/' {
+			return structuredError("invalid token %q", v)
+		}
+	}
+	b.WriteString(string(v))
+	return nil
+}
+
+func writeDisplayString(b *strings.Builder, v DisplayString) error {
+	if !utf8.ValidString(string(v)) {
+		return structuredError("invalid utf-8 in display string")
+	}
+
+	b.WriteString(`%"`)
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c == '%' || c == '"' || c < 0x20 || c > 0x7e {
+			fmt.Fprintf(b, "%%%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isLCAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isLowerHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f')
+}
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
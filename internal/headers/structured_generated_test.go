@@ -0,0 +1,347 @@
+package headers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// generated cases cover the same ground as *-generated.json files of
+// github.com/httpwg/structured-field-tests: every ASCII character in keys,
+// tokens and strings, all lengths of numbers and large fields. Suite files
+// with the same names in testdata replace them
+
+// generatedSFSuites returns parsing suites, serialisation suites are under
+// "serialisation-tests/"
+func generatedSFSuites(t *testing.T) map[string][]sfTest {
+	suites := map[string][]sfTest{
+		"key-generated.json":    generatedKeyTests(t),
+		"token-generated.json":  generatedTokenTests(t),
+		"string-generated.json": generatedStringTests(t),
+		"number-generated.json": generatedNumberTests(t),
+		"large-generated.json":  generatedLargeTests(t),
+	}
+	for name, tests := range generatedSerialisationTests(t) {
+		suites["serialisation-tests/"+name] = tests
+	}
+	return suites
+}
+
+const tchars = "!#$%&'*+-.^_`|~0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func sfToken(v string) any {
+	return map[string]any{"__type": "token", "value": v}
+}
+
+func sfCase(t *testing.T, name, headerType string, raw string, expected any, canonical ...string) sfTest {
+	t.Helper()
+	tc := sfTest{Name: name, HeaderType: headerType, Raw: []string{raw}, Canonical: canonical}
+	if expected == nil {
+		tc.MustFail = true
+		return tc
+	}
+
+	data, err := json.Marshal(expected)
+	require.NoError(t, err)
+	tc.Expected = data
+	return tc
+}
+
+// sfSerializeCase fails unless ok, canonical is serialized expected
+func sfSerializeCase(t *testing.T, name, headerType string, expected any, ok bool, canonical string) sfTest {
+	t.Helper()
+	data, err := json.Marshal(expected)
+	require.NoError(t, err)
+
+	tc := sfTest{Name: name, HeaderType: headerType, Expected: data, MustFail: !ok}
+	if ok {
+		tc.Canonical = []string{canonical}
+	}
+	return tc
+}
+
+func isKeyStart(c byte) bool {
+	return isLCAlpha(c) || c == '*'
+}
+
+func isTokenChar(c byte) bool {
+	return strings.IndexByte(tchars, c) != -1 || c == ':' || c == '/'
+}
+
+func generatedKeyTests(t *testing.T) []sfTest {
+	tests := []sfTest{}
+
+	for i := 0; i < 0x7f; i++ {
+		c := byte(i)
+		s := string(c)
+
+		name := fmt.Sprintf("0x%02x in dictionary key", c)
+		switch {
+		case isKeyChar(c):
+			tests = append(tests, sfCase(t, name, "dictionary", "a"+s+"a=1", []any{[]any{"a" + s + "a", []any{1, []any{}}}}))
+		case c == ',':
+			tests = append(tests, sfCase(t, name, "dictionary", "a"+s+"a=1", []any{[]any{"a", []any{1, []any{}}}}, "a=1"))
+		case c == ';':
+			tests = append(tests, sfCase(t, name, "dictionary", "a"+s+"a=1", []any{[]any{"a", []any{true, []any{[]any{"a", 1}}}}}))
+		default:
+			tests = append(tests, sfCase(t, name, "dictionary", "a"+s+"a=1", nil))
+		}
+
+		name = fmt.Sprintf("0x%02x starting dictionary key", c)
+		switch {
+		case isKeyStart(c):
+			tests = append(tests, sfCase(t, name, "dictionary", s+"a=1", []any{[]any{s + "a", []any{1, []any{}}}}))
+		case c == ' ':
+			tests = append(tests, sfCase(t, name, "dictionary", s+"a=1", []any{[]any{"a", []any{1, []any{}}}}, "a=1"))
+		default:
+			tests = append(tests, sfCase(t, name, "dictionary", s+"a=1", nil))
+		}
+
+		name = fmt.Sprintf("0x%02x in parameterised list key", c)
+		switch {
+		case isKeyChar(c):
+			tests = append(tests, sfCase(t, name, "list", "foo; a"+s+"a=1", []any{[]any{sfToken("foo"), []any{[]any{"a" + s + "a", 1}}}}, "foo;a"+s+"a=1"))
+		case c == ';':
+			tests = append(tests, sfCase(t, name, "list", "foo; a"+s+"a=1", []any{[]any{sfToken("foo"), []any{[]any{"a", 1}}}}, "foo;a=1"))
+		default:
+			tests = append(tests, sfCase(t, name, "list", "foo; a"+s+"a=1", nil))
+		}
+
+		name = fmt.Sprintf("0x%02x starting parameterised list key", c)
+		switch {
+		case isKeyStart(c):
+			tests = append(tests, sfCase(t, name, "list", "foo; "+s+"a=1", []any{[]any{sfToken("foo"), []any{[]any{s + "a", 1}}}}, "foo;"+s+"a=1"))
+		case c == ' ':
+			tests = append(tests, sfCase(t, name, "list", "foo; "+s+"a=1", []any{[]any{sfToken("foo"), []any{[]any{"a", 1}}}}, "foo;a=1"))
+		default:
+			tests = append(tests, sfCase(t, name, "list", "foo; "+s+"a=1", nil))
+		}
+	}
+
+	return tests
+}
+
+func generatedTokenTests(t *testing.T) []sfTest {
+	tests := []sfTest{}
+
+	for i := 0; i < 0x7f; i++ {
+		c := byte(i)
+		s := string(c)
+
+		name := fmt.Sprintf("0x%02x in token", c)
+		switch {
+		case isTokenChar(c):
+			tests = append(tests, sfCase(t, name, "item", "a"+s+"a", []any{sfToken("a" + s + "a"), []any{}}))
+		case c == ';':
+			tests = append(tests, sfCase(t, name, "item", "a"+s+"a", []any{sfToken("a"), []any{[]any{"a", true}}}))
+		default:
+			tests = append(tests, sfCase(t, name, "item", "a"+s+"a", nil))
+		}
+
+		name = fmt.Sprintf("0x%02x starting token", c)
+		switch {
+		case isAlpha(c) || c == '*':
+			tests = append(tests, sfCase(t, name, "item", s+"a", []any{sfToken(s + "a"), []any{}}))
+		case c == ' ':
+			tests = append(tests, sfCase(t, name, "item", s+"a", []any{sfToken("a"), []any{}}, "a"))
+		default:
+			tests = append(tests, sfCase(t, name, "item", s+"a", nil))
+		}
+	}
+
+	return tests
+}
+
+func generatedStringTests(t *testing.T) []sfTest {
+	tests := []sfTest{}
+
+	for i := 0; i < 0x7f; i++ {
+		c := byte(i)
+		s := string(c)
+
+		name := fmt.Sprintf("0x%02x in string", c)
+		if c >= 0x20 && c != '"' && c != '\\' {
+			tests = append(tests, sfCase(t, name, "item", `"`+s+`"`, []any{s, []any{}}))
+		} else {
+			tests = append(tests, sfCase(t, name, "item", `"`+s+`"`, nil))
+		}
+
+		name = fmt.Sprintf("Escaped 0x%02x in string", c)
+		if c == '"' || c == '\\' {
+			tests = append(tests, sfCase(t, name, "item", `"\`+s+`"`, []any{s, []any{}}))
+		} else {
+			tests = append(tests, sfCase(t, name, "item", `"\`+s+`"`, nil))
+		}
+	}
+
+	return tests
+}
+
+func generatedNumberTests(t *testing.T) []sfTest {
+	tests := []sfTest{}
+
+	for digits := 1; digits <= maxIntegerDigits+1; digits++ {
+		ok := digits <= maxIntegerDigits
+
+		zeros := strings.Repeat("0", digits)
+		ones := strings.Repeat("1", digits)
+		if ok {
+			tests = append(tests,
+				sfCase(t, fmt.Sprintf("%d digits of zero", digits), "item", zeros, []any{json.Number("0"), []any{}}, "0"),
+				sfCase(t, fmt.Sprintf("%d digit small integer", digits), "item", ones, []any{json.Number(ones), []any{}}),
+				sfCase(t, fmt.Sprintf("%d digit small negative integer", digits), "item", "-"+ones, []any{json.Number("-" + ones), []any{}}),
+			)
+		} else {
+			tests = append(tests,
+				sfCase(t, fmt.Sprintf("%d digits of zero", digits), "item", zeros, nil),
+				sfCase(t, fmt.Sprintf("%d digit small integer", digits), "item", ones, nil),
+				sfCase(t, fmt.Sprintf("%d digit small negative integer", digits), "item", "-"+ones, nil),
+			)
+		}
+	}
+
+	for intDigits := 1; intDigits <= maxDecimalInt+1; intDigits++ {
+		for fracDigits := 0; fracDigits <= maxDecimalFrac+1; fracDigits++ {
+			ok := intDigits <= maxDecimalInt && fracDigits >= 1 && fracDigits <= maxDecimalFrac
+
+			zeros := strings.Repeat("0", intDigits) + "." + strings.Repeat("0", fracDigits)
+			ones := strings.Repeat("1", intDigits) + "." + strings.Repeat("1", fracDigits)
+			name := fmt.Sprintf("decimal with %d integer and %d fractional digits", intDigits, fracDigits)
+
+			if ok {
+				tests = append(tests,
+					sfCase(t, name+" of zero", "item", zeros, []any{json.Number("0.0"), []any{}}, "0.0"),
+					sfCase(t, name, "item", ones, []any{json.Number(ones), []any{}}),
+					sfCase(t, "negative "+name, "item", "-"+ones, []any{json.Number("-" + ones), []any{}}),
+				)
+			} else {
+				tests = append(tests,
+					sfCase(t, name+" of zero", "item", zeros, nil),
+					sfCase(t, name, "item", ones, nil),
+					sfCase(t, "negative "+name, "item", "-"+ones, nil),
+				)
+			}
+		}
+	}
+
+	return tests
+}
+
+func generatedLargeTests(t *testing.T) []sfTest {
+	names := func(prefix string, n int) []string {
+		s := make([]string, n)
+		for i := range s {
+			s[i] = fmt.Sprintf("%s%d", prefix, i)
+		}
+		return s
+	}
+
+	dict := []any{}
+	dictRaw := []string{}
+	for _, key := range names("a", 1024) {
+		dict = append(dict, []any{key, []any{1, []any{}}})
+		dictRaw = append(dictRaw, key+"=1")
+	}
+
+	list := []any{}
+	for _, token := range names("a", 1024) {
+		list = append(list, []any{sfToken(token), []any{}})
+	}
+
+	innerItems := []any{}
+	for _, token := range names("b", 256) {
+		innerItems = append(innerItems, []any{sfToken(token), []any{}})
+	}
+
+	params := []any{}
+	paramsRaw := ""
+	for _, key := range names("a", 256) {
+		params = append(params, []any{key, 1})
+		paramsRaw += ";" + key + "=1"
+	}
+
+	key := strings.Repeat("a", 64)
+	str := strings.Repeat("a", 1024)
+	escaped := strings.Repeat(`"\`, 512)
+	token := strings.Repeat("a", 512)
+
+	return []sfTest{
+		sfCase(t, "large dictionary", "dictionary", strings.Join(dictRaw, ", "), dict),
+		sfCase(t, "large dictionary key", "dictionary", key+"=1", []any{[]any{key, []any{1, []any{}}}}),
+		sfCase(t, "large list", "list", strings.Join(names("a", 1024), ", "), list),
+		sfCase(t, "large inner list", "list", "("+strings.Join(names("b", 256), " ")+")", []any{[]any{innerItems, []any{}}}),
+		sfCase(t, "large parameterised list", "list", "foo"+paramsRaw, []any{[]any{sfToken("foo"), params}}),
+		sfCase(t, "large params", "item", "foo"+paramsRaw, []any{sfToken("foo"), params}),
+		sfCase(t, "large param key", "item", "foo;"+key+"=1", []any{sfToken("foo"), []any{[]any{key, 1}}}),
+		sfCase(t, "large string", "item", `"`+str+`"`, []any{str, []any{}}),
+		sfCase(t, "large escaped string", "item", `"`+escapeSF(escaped)+`"`, []any{escaped, []any{}}),
+		sfCase(t, "large token", "item", token, []any{sfToken(token), []any{}}),
+	}
+}
+
+func escapeSF(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// generatedSerialisationTests returns suites by file name
+func generatedSerialisationTests(t *testing.T) map[string][]sfTest {
+	suites := map[string][]sfTest{}
+	add := func(file string, tests ...sfTest) {
+		suites[file] = append(suites[file], tests...)
+	}
+
+	for i := 0; i < 0x7f; i++ {
+		c := byte(i)
+		s := string(c)
+
+		add("key-generated.json",
+			sfSerializeCase(t, fmt.Sprintf("0x%02x in dictionary key", c), "dictionary",
+				[]any{[]any{"a" + s + "a", []any{1, []any{}}}}, isKeyChar(c), "a"+s+"a=1"),
+			sfSerializeCase(t, fmt.Sprintf("0x%02x starting dictionary key", c), "dictionary",
+				[]any{[]any{s + "a", []any{1, []any{}}}}, isKeyStart(c), s+"a=1"),
+			sfSerializeCase(t, fmt.Sprintf("0x%02x in parameterised list key", c), "list",
+				[]any{[]any{sfToken("foo"), []any{[]any{"a" + s + "a", 1}}}}, isKeyChar(c), "foo;a"+s+"a=1"),
+			sfSerializeCase(t, fmt.Sprintf("0x%02x starting parameterised list key", c), "list",
+				[]any{[]any{sfToken("foo"), []any{[]any{s + "a", 1}}}}, isKeyStart(c), "foo;"+s+"a=1"),
+		)
+		add("token-generated.json",
+			sfSerializeCase(t, fmt.Sprintf("0x%02x in token", c), "item",
+				[]any{sfToken("a" + s + "a"), []any{}}, isTokenChar(c), "a"+s+"a"),
+			sfSerializeCase(t, fmt.Sprintf("0x%02x starting token", c), "item",
+				[]any{sfToken(s + "a"), []any{}}, isAlpha(c) || c == '*', s+"a"),
+		)
+		add("string-generated.json",
+			sfSerializeCase(t, fmt.Sprintf("0x%02x in string", c), "item",
+				[]any{s, []any{}}, c >= 0x20, `"`+escapeSF(s)+`"`),
+		)
+	}
+
+	for digits := 1; digits <= maxIntegerDigits+1; digits++ {
+		nines := strings.Repeat("9", digits)
+		ok := digits <= maxIntegerDigits
+		add("number-generated.json",
+			sfSerializeCase(t, fmt.Sprintf("%d digit integer", digits), "item", []any{json.Number(nines), []any{}}, ok, nines),
+			sfSerializeCase(t, fmt.Sprintf("%d digit negative integer", digits), "item", []any{json.Number("-" + nines), []any{}}, ok, "-"+nines),
+		)
+	}
+
+	for digits := 1; digits <= maxDecimalInt+1; digits++ {
+		ones := strings.Repeat("1", digits) + ".5"
+		add("number-generated.json", sfSerializeCase(t, fmt.Sprintf("decimal with %d integer digits", digits), "item",
+			[]any{json.Number(ones), []any{}}, digits <= maxDecimalInt, ones))
+	}
+
+	str := strings.Repeat("a", 1024)
+	token := strings.Repeat("a", 512)
+	key := strings.Repeat("a", 64)
+	add("large-generated.json",
+		sfSerializeCase(t, "large string", "item", []any{str, []any{}}, true, `"`+str+`"`),
+		sfSerializeCase(t, "large token", "item", []any{sfToken(token), []any{}}, true, token),
+		sfSerializeCase(t, "large dictionary key", "dictionary", []any{[]any{key, []any{1, []any{}}}}, true, key+"=1"),
+	)
+
+	return suites
+}
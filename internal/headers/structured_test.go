@@ -0,0 +1,266 @@
+package headers
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sfTestsDir = "testdata/structured-field-tests"
+
+// sfTest is a case in format of github.com/httpwg/structured-field-tests
+type sfTest struct {
+	Name       string          `json:"name"`
+	Raw        []string        `json:"raw"`
+	HeaderType string          `json:"header_type"`
+	Expected   json.RawMessage `json:"expected"`
+	MustFail   bool            `json:"must_fail"`
+	CanFail    bool            `json:"can_fail"`
+	Canonical  []string        `json:"canonical"`
+}
+
+func loadSFTests(t *testing.T, pattern string) map[string][]sfTest {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(sfTestsDir, pattern))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	suites := map[string][]sfTest{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		var tests []sfTest
+		require.NoError(t, json.Unmarshal(data, &tests), file)
+		suites[filepath.Base(file)] = tests
+	}
+	return suites
+}
+
+// withGenerated adds generated suites of dir which are not in testdata.
+// Vendored suite has its own generated files, it is run as is
+func withGenerated(t *testing.T, suites map[string][]sfTest, dir string) map[string][]sfTest {
+	if _, err := os.Stat(filepath.Join(sfTestsDir, "COMMIT")); err == nil {
+		return suites
+	}
+
+	for name, tests := range generatedSFSuites(t) {
+		name, ok := strings.CutPrefix(name, dir)
+		if _, vendored := suites[name]; ok && !strings.Contains(name, "/") && !vendored {
+			suites[name] = tests
+		}
+	}
+	return suites
+}
+
+func TestStructuredFieldsParse(t *testing.T) {
+	for file, tests := range withGenerated(t, loadSFTests(t, "*.json"), "") {
+		for _, tc := range tests {
+			t.Run(file+"/"+tc.Name, func(t *testing.T) {
+				got, err := parseSF(tc.HeaderType, tc.Raw)
+
+				if tc.MustFail {
+					assert.ErrorIs(t, err, ErrInvalidStructuredField)
+					return
+				}
+				if tc.CanFail && err != nil {
+					return
+				}
+				require.NoError(t, err)
+
+				want := decodeSFExpected(t, tc.HeaderType, tc.Expected)
+				assert.Equal(t, want, got)
+
+				canonical := tc.Canonical
+				if canonical == nil {
+					canonical = tc.Raw
+				}
+				serialized, err := serializeSF(tc.HeaderType, got)
+				require.NoError(t, err)
+				if len(canonical) == 0 {
+					assert.Empty(t, serialized)
+				} else {
+					assert.Equal(t, canonical[0], serialized)
+				}
+			})
+		}
+	}
+}
+
+func TestStructuredFieldsSerialize(t *testing.T) {
+	for file, tests := range withGenerated(t, loadSFTests(t, "serialisation-tests/*.json"), "serialisation-tests/") {
+		for _, tc := range tests {
+			t.Run(file+"/"+tc.Name, func(t *testing.T) {
+				value := decodeSFExpected(t, tc.HeaderType, tc.Expected)
+				serialized, err := serializeSF(tc.HeaderType, value)
+
+				if tc.MustFail {
+					assert.ErrorIs(t, err, ErrInvalidStructuredField)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tc.Canonical[0], serialized)
+			})
+		}
+	}
+}
+
+func TestStructuredFieldsAccessors(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Priority", "u=1, i")
+	h.Set("Cache-Status", `ExampleCache; hit, "cdn"; fwd=uri-miss; stored`)
+	h.Set("Signature-Input", `sig1=("@method" "@authority");created=1618884473;keyid="test-key"`)
+
+	priority, ok := h.GetDictionary("Priority")
+	require.True(t, ok)
+	u, _ := priority.Get("u")
+	assert.Equal(t, Item{Value: int64(1), Params: Params{}}, u)
+	i, _ := priority.Get("i")
+	assert.Equal(t, Item{Value: true, Params: Params{}}, i)
+
+	status, ok := h.GetStructuredList("Cache-Status")
+	require.True(t, ok)
+	require.Len(t, status, 2)
+	fwd, _ := status[1].(Item).Params.Get("fwd")
+	assert.Equal(t, Token("uri-miss"), fwd)
+
+	input, ok := h.GetDictionary("Signature-Input")
+	require.True(t, ok)
+	sig, _ := input.Get("sig1")
+	require.IsType(t, InnerList{}, sig)
+	assert.Equal(t, "@authority", sig.(InnerList).Items[1].Value)
+	created, _ := sig.(InnerList).Params.Get("created")
+	assert.Equal(t, int64(1618884473), created)
+
+	require.NoError(t, h.SetItem("Example-Date", Item{Value: time.Unix(1659578233, 0), Params: Params{{Key: "final", Value: true}}}))
+	assert.Equal(t, "@1659578233;final", h["example-date"])
+
+	require.NoError(t, h.SetDictionary("Priority", Dictionary{{Key: "u", Member: Item{Value: 5}}}))
+	assert.Equal(t, "u=5", h["priority"])
+
+	assert.Error(t, h.SetStructuredList("Example-List", StructuredList{Item{Value: "naïve"}}))
+	_, ok = h.GetItem("Priority-Missing")
+	assert.False(t, ok)
+}
+
+func parseSF(headerType string, raw []string) (any, error) {
+	switch headerType {
+	case "item":
+		return ParseItem(raw...)
+	case "list":
+		return ParseStructuredList(raw...)
+	case "dictionary":
+		return ParseDictionary(raw...)
+	}
+	panic("unknown header type " + headerType)
+}
+
+func serializeSF(headerType string, v any) (string, error) {
+	switch headerType {
+	case "item":
+		return SerializeItem(v.(Item))
+	case "list":
+		return SerializeList(v.(StructuredList))
+	case "dictionary":
+		return SerializeDictionary(v.(Dictionary))
+	}
+	panic("unknown header type " + headerType)
+}
+
+// decodeSFExpected converts JSON representation of the test suite to
+// structured field types
+func decodeSFExpected(t *testing.T, headerType string, raw json.RawMessage) any {
+	t.Helper()
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var v any
+	require.NoError(t, d.Decode(&v))
+
+	switch headerType {
+	case "item":
+		return sfItem(t, v)
+	case "list":
+		list := StructuredList{}
+		for _, m := range v.([]any) {
+			list = append(list, sfMember(t, m))
+		}
+		return list
+	case "dictionary":
+		dict := Dictionary{}
+		for _, m := range v.([]any) {
+			pair := m.([]any)
+			dict = append(dict, DictMember{Key: pair[0].(string), Member: sfMember(t, pair[1])})
+		}
+		return dict
+	}
+	t.Fatalf("unknown header type %s", headerType)
+	return nil
+}
+
+func sfMember(t *testing.T, v any) Member {
+	pair := v.([]any)
+	if items, ok := pair[0].([]any); ok {
+		list := InnerList{Items: []Item{}, Params: sfParams(t, pair[1])}
+		for _, item := range items {
+			list.Items = append(list.Items, sfItem(t, item))
+		}
+		return list
+	}
+	return sfItem(t, v)
+}
+
+func sfItem(t *testing.T, v any) Item {
+	pair := v.([]any)
+	return Item{Value: sfBareItem(t, pair[0]), Params: sfParams(t, pair[1])}
+}
+
+func sfParams(t *testing.T, v any) Params {
+	params := Params{}
+	for _, p := range v.([]any) {
+		pair := p.([]any)
+		params = append(params, Param{Key: pair[0].(string), Value: sfBareItem(t, pair[1])})
+	}
+	return params
+}
+
+func sfBareItem(t *testing.T, v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			f, err := v.Float64()
+			require.NoError(t, err)
+			return f
+		}
+		n, err := v.Int64()
+		require.NoError(t, err)
+		return n
+	case string, bool:
+		return v
+	case map[string]any:
+		switch v["__type"] {
+		case "token":
+			return Token(v["value"].(string))
+		case "binary":
+			b, err := base32.StdEncoding.DecodeString(v["value"].(string))
+			require.NoError(t, err)
+			return b
+		case "date":
+			n, err := v["value"].(json.Number).Int64()
+			require.NoError(t, err)
+			return time.Unix(n, 0).UTC()
+		case "displaystring":
+			return DisplayString(v["value"].(string))
+		}
+	}
+	t.Fatalf("unknown bare item %v", v)
+	return nil
+}
@@ -0,0 +1,20 @@
+#!/bin/sh
+# Replaces the suite of this directory with upstream files of a commit of
+# https://github.com/httpwg/structured-field-tests and records the commit.
+#
+# usage: ./vendor.sh <commit>
+set -eu
+
+commit=${1:?usage: $0 <commit>}
+dir=$(cd "$(dirname "$0")" && pwd)
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+curl -fsSL "https://codeload.github.com/httpwg/structured-field-tests/tar.gz/$commit" |
+	tar -xz -C "$tmp" --strip-components=1
+
+find "$dir" -name '*.json' -delete
+cp "$tmp"/*.json "$dir"/
+mkdir -p "$dir/serialisation-tests"
+cp "$tmp"/serialisation-tests/*.json "$dir/serialisation-tests"/
+echo "$commit" >"$dir/COMMIT"
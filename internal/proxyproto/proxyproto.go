@@ -0,0 +1,339 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107
+	CRLF        = "\r\n"
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+type Command int
+
+const (
+	// connection was opened by the proxy itself, e.g. for health checks
+	CommandLocal Command = iota
+	CommandProxy
+)
+
+type Header struct {
+	Version     int
+	Command     Command
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadHeader reads PROXY protocol v1 or v2 header from the start of r
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	// v1 header may be shorter than v2 signature and nothing may follow
+	// it, so the rest of the signature is only waited for if it's not v1
+	prefix, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy header: %v", err)
+	}
+	if bytes.Equal(prefix, []byte(v1Prefix)) {
+		return readV1(r)
+	}
+
+	if !bytes.HasPrefix(v2Signature, prefix) {
+		return nil, fmt.Errorf("proxy header signature not found")
+	}
+	sig, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy header: %v", err)
+	}
+	if bytes.Equal(sig, v2Signature) {
+		return readV2(r)
+	}
+
+	return nil, fmt.Errorf("proxy header signature not found")
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	line := make([]byte, 0, v1MaxLength)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proxy v1 header: %v", err)
+		}
+		line = append(line, b)
+
+		if bytes.HasSuffix(line, []byte(CRLF)) {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return nil, fmt.Errorf("proxy v1 header is too long")
+		}
+	}
+
+	parts := strings.Split(string(line[:len(line)-len(CRLF)]), " ")
+	h := &Header{Version: 1, Command: CommandProxy}
+
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		h.Command = CommandLocal
+		return h, nil
+	}
+
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("proxy v1 header parts not equal six: %s", line)
+	}
+
+	src, err := parseV1Addr(parts[1], parts[2], parts[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(parts[1], parts[3], parts[5])
+	if err != nil {
+		return nil, err
+	}
+
+	h.Source = src
+	h.Destination = dst
+
+	return h, nil
+}
+
+func parseV1Addr(proto, ip, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy v1 address: %s", ip)
+	}
+
+	if (proto == "TCP4" && !addr.Is4()) || (proto == "TCP6" && !addr.Is6()) {
+		return nil, fmt.Errorf("proxy v1 address %s does not match protocol %s", ip, proto)
+	}
+	if proto != "TCP4" && proto != "TCP6" {
+		return nil, fmt.Errorf("unknown proxy v1 protocol: %s", proto)
+	}
+
+	// leading zeros are not allowed
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid proxy v1 port: %s", port)
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+const (
+	v2HeaderLength = 16
+	v2Version      = 0x2
+
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+	familyUnix   = 0x3
+
+	transportStream = 0x1
+	transportDgram  = 0x2
+)
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read proxy v2 header: %v", err)
+	}
+
+	if fixed[12]>>4 != v2Version {
+		return nil, fmt.Errorf("unsupported proxy v2 version: %d", fixed[12]>>4)
+	}
+
+	h := &Header{Version: 2}
+
+	switch fixed[12] & 0xF {
+	case 0x0:
+		h.Command = CommandLocal
+	case 0x1:
+		h.Command = CommandProxy
+	default:
+		return nil, fmt.Errorf("unknown proxy v2 command: %d", fixed[12]&0xF)
+	}
+
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("failed to read proxy v2 addresses: %v", err)
+	}
+
+	// addresses of LOCAL connections must be ignored
+	if h.Command == CommandLocal {
+		return h, nil
+	}
+
+	family, transport := fixed[13]>>4, fixed[13]&0xF
+
+	var addrLen, ipLen int
+	switch family {
+	case familyUnspec:
+		return h, nil
+	case familyInet:
+		addrLen, ipLen = 12, 4
+	case familyInet6:
+		addrLen, ipLen = 36, 16
+	case familyUnix:
+		addrLen = 216
+	default:
+		return nil, fmt.Errorf("unknown proxy v2 address family: %d", family)
+	}
+
+	if length < addrLen {
+		return nil, fmt.Errorf("proxy v2 addresses too short: %d", length)
+	}
+
+	if family == familyUnix {
+		h.Source = &net.UnixAddr{Net: "unix", Name: unixPath(payload[:108])}
+		h.Destination = &net.UnixAddr{Net: "unix", Name: unixPath(payload[108:216])}
+		return h, nil
+	}
+
+	srcIP, _ := netip.AddrFromSlice(payload[:ipLen])
+	dstIP, _ := netip.AddrFromSlice(payload[ipLen : 2*ipLen])
+	srcPort := binary.BigEndian.Uint16(payload[2*ipLen:])
+	dstPort := binary.BigEndian.Uint16(payload[2*ipLen+2:])
+
+	src := netip.AddrPortFrom(srcIP, srcPort)
+	dst := netip.AddrPortFrom(dstIP, dstPort)
+
+	switch transport {
+	case transportStream:
+		h.Source = net.TCPAddrFromAddrPort(src)
+		h.Destination = net.TCPAddrFromAddrPort(dst)
+	case transportDgram:
+		h.Source = net.UDPAddrFromAddrPort(src)
+		h.Destination = net.UDPAddrFromAddrPort(dst)
+	default:
+		return nil, fmt.Errorf("unknown proxy v2 transport: %d", transport)
+	}
+
+	return h, nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Conn reports addresses from PROXY header instead of the proxy ones
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	header *Header
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// ReadFrom keeps zero-copy of the underlying connection available
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+func (c *Conn) Header() *Header {
+	return c.header
+}
+
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// ProxyAddr is the address of the proxy connected to us
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Wrap reads PROXY header if conn comes from one of trusted networks,
+// connections from other addresses are returned as is
+func Wrap(conn net.Conn, trusted []netip.Prefix, timeout time.Duration) (net.Conn, error) {
+	if !IsTrusted(conn.RemoteAddr(), trusted) {
+		return conn, nil
+	}
+
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	reader := bufio.NewReader(conn)
+	header, err := ReadHeader(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Conn: conn, reader: reader, header: header}, nil
+}
+
+func IsTrusted(addr net.Addr, trusted []netip.Prefix) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParsePrefixes parses comma separated CIDR list
+func ParsePrefixes(s string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
@@ -0,0 +1,186 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func v2Header(command, family byte, addrs []byte) []byte {
+	data := append([]byte{}, v2Signature...)
+	data = append(data, 0x20|command, family)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(addrs)))
+	return append(data, addrs...)
+}
+
+func TestReadHeader(t *testing.T) {
+	t.Run("ok, v1 TCP4", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+		h, err := ReadHeader(r)
+		require.NoError(t, err)
+		assert.Equal(t, 1, h.Version)
+		assert.Equal(t, CommandProxy, h.Command)
+		assert.Equal(t, "192.168.0.1:56324", h.Source.String())
+		assert.Equal(t, "10.0.0.1:443", h.Destination.String())
+
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+	})
+	t.Run("ok, v1 TCP6", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n"))
+		h, err := ReadHeader(r)
+		require.NoError(t, err)
+		assert.Equal(t, "[2001:db8::1]:1000", h.Source.String())
+		assert.Equal(t, "[2001:db8::2]:80", h.Destination.String())
+	})
+	t.Run("ok, v1 UNKNOWN", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n"))
+		h, err := ReadHeader(r)
+		require.NoError(t, err)
+		assert.Equal(t, CommandLocal, h.Command)
+		assert.Nil(t, h.Source)
+	})
+	t.Run("ok, v1 UNKNOWN without data after it", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+		go client.Write([]byte("PROXY UNKNOWN\r\n"))
+
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		h, err := ReadHeader(bufio.NewReader(server))
+		require.NoError(t, err)
+		assert.Equal(t, CommandLocal, h.Command)
+	})
+	t.Run("ok, v2 TCP4", func(t *testing.T) {
+		addrs := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xDC, 0x04, 0x01, 0xBB}
+		// TLV after addresses is skipped
+		addrs = append(addrs, 0x04, 0x00, 0x01, 0xFF)
+		data := append(v2Header(0x1, 0x11, addrs), []byte("GET")...)
+
+		r := bufio.NewReader(bytes.NewReader(data))
+		h, err := ReadHeader(r)
+		require.NoError(t, err)
+		assert.Equal(t, 2, h.Version)
+		assert.Equal(t, CommandProxy, h.Command)
+		assert.Equal(t, "192.168.0.1:56324", h.Source.String())
+		assert.Equal(t, "10.0.0.1:443", h.Destination.String())
+
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "GET", string(rest))
+	})
+	t.Run("ok, v2 TCP6", func(t *testing.T) {
+		src := netip.MustParseAddr("2001:db8::1").As16()
+		dst := netip.MustParseAddr("2001:db8::2").As16()
+		addrs := append(src[:], dst[:]...)
+		addrs = append(addrs, 0x03, 0xE8, 0x00, 0x50)
+
+		h, err := ReadHeader(bufio.NewReader(bytes.NewReader(v2Header(0x1, 0x21, addrs))))
+		require.NoError(t, err)
+		assert.Equal(t, "[2001:db8::1]:1000", h.Source.String())
+		assert.Equal(t, "[2001:db8::2]:80", h.Destination.String())
+	})
+	t.Run("ok, v2 LOCAL", func(t *testing.T) {
+		h, err := ReadHeader(bufio.NewReader(bytes.NewReader(v2Header(0x0, 0x00, nil))))
+		require.NoError(t, err)
+		assert.Equal(t, CommandLocal, h.Command)
+		assert.Nil(t, h.Source)
+	})
+	t.Run("fail, no header", func(t *testing.T) {
+		_, err := ReadHeader(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+		require.Error(t, err)
+	})
+	t.Run("fail, malformed v1", func(t *testing.T) {
+		for _, data := range []string{
+			"PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n",
+			"PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n",
+			"PROXY TCP4 192.168.0.1 10.0.0.1 056324 443\r\n",
+			"PROXY TCP4 192.168.0.1 10.0.0.1 70000 443\r\n",
+			"PROXY UDP4 192.168.0.1 10.0.0.1 1 443\r\n",
+			"PROXY TCP4 192.168.0.1 10.0.0.1 1 443" + strings.Repeat(" ", 100) + "\r\n",
+		} {
+			_, err := ReadHeader(bufio.NewReader(strings.NewReader(data)))
+			require.Error(t, err, data)
+		}
+	})
+	t.Run("fail, malformed v2", func(t *testing.T) {
+		short := v2Header(0x1, 0x11, []byte{192, 168, 0, 1})
+		_, err := ReadHeader(bufio.NewReader(bytes.NewReader(short)))
+		require.Error(t, err)
+
+		truncated := v2Header(0x1, 0x11, make([]byte, 12))
+		_, err = ReadHeader(bufio.NewReader(bytes.NewReader(truncated[:20])))
+		require.Error(t, err)
+
+		badCommand := v2Header(0x5, 0x11, make([]byte, 12))
+		_, err = ReadHeader(bufio.NewReader(bytes.NewReader(badCommand)))
+		require.Error(t, err)
+	})
+}
+
+func TestWrap(t *testing.T) {
+	pipe := func(t *testing.T, data string) net.Conn {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		_, err = client.Write([]byte(data))
+		require.NoError(t, err)
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("ok, trusted source", func(t *testing.T) {
+		conn := pipe(t, "PROXY TCP4 203.0.113.7 10.0.0.1 4000 443\r\nbody")
+		trusted, err := ParsePrefixes("127.0.0.0/8, 10.0.0.0/8")
+		require.NoError(t, err)
+
+		wrapped, err := Wrap(conn, trusted, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "203.0.113.7:4000", wrapped.RemoteAddr().String())
+		assert.Equal(t, "10.0.0.1:443", wrapped.LocalAddr().String())
+		assert.Equal(t, conn.RemoteAddr(), wrapped.(*Conn).ProxyAddr())
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(wrapped, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "body", string(buf))
+	})
+	t.Run("ok, untrusted source is not parsed", func(t *testing.T) {
+		conn := pipe(t, "PROXY TCP4 203.0.113.7 10.0.0.1 4000 443\r\n")
+		trusted, err := ParsePrefixes("10.0.0.0/8")
+		require.NoError(t, err)
+
+		wrapped, err := Wrap(conn, trusted, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, conn, wrapped)
+	})
+	t.Run("fail, trusted source without header", func(t *testing.T) {
+		conn := pipe(t, "GET / HTTP/1.1\r\n\r\n")
+		trusted, err := ParsePrefixes("127.0.0.1/32")
+		require.NoError(t, err)
+
+		_, err = Wrap(conn, trusted, time.Second)
+		require.Error(t, err)
+	})
+	t.Run("fail, invalid CIDR", func(t *testing.T) {
+		_, err := ParsePrefixes("10.0.0.0/33")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,305 @@
+package ranges
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+const (
+	unit = "bytes"
+	// more ranges than this are not worth the multipart overhead
+	maxRanges = 100
+)
+
+var (
+	// ErrInvalid means Range header must be ignored and whole content sent
+	ErrInvalid = errors.New("invalid range")
+	// ErrUnsatisfiable means none of the ranges overlaps content
+	ErrUnsatisfiable = errors.New("range not satisfiable")
+)
+
+type Range struct {
+	Start  int64
+	Length int64
+}
+
+func (r Range) ContentRange(size int64) string {
+	return fmt.Sprintf("%s %d-%d/%d", unit, r.Start, r.Start+r.Length-1, size)
+}
+
+// Parse parses Range header value for content of size bytes as in RFC 9110
+// section 14.1.2. Ranges which do not overlap content are dropped, if
+// nothing left ErrUnsatisfiable is returned
+func Parse(header string, size int64) ([]Range, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), unit+"=")
+	if !ok {
+		return nil, ErrInvalid
+	}
+
+	parts := strings.Split(spec, ",")
+	if len(parts) > maxRanges {
+		return nil, ErrInvalid
+	}
+
+	ranges := []Range{}
+	total := int64(0)
+	specs := 0
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		specs++
+
+		r, satisfiable, err := parseRange(part, size)
+		if err != nil {
+			return nil, err
+		}
+		if !satisfiable {
+			continue
+		}
+
+		ranges = append(ranges, r)
+		total += r.Length
+	}
+
+	// range set has at least one range, empty list elements don't count
+	if specs == 0 {
+		return nil, ErrInvalid
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiable
+	}
+
+	// overlapping ranges asking more than the whole content
+	if total > size {
+		return nil, ErrInvalid
+	}
+
+	return ranges, nil
+}
+
+func parseRange(spec string, size int64) (Range, bool, error) {
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return Range{}, false, ErrInvalid
+	}
+	first = strings.TrimSpace(first)
+	last = strings.TrimSpace(last)
+
+	// suffix-range = "-" suffix-length
+	if first == "" {
+		n, err := parseDigits(last)
+		if err != nil {
+			return Range{}, false, err
+		}
+		if n == 0 || size == 0 {
+			return Range{}, false, nil
+		}
+		n = min(n, size)
+		return Range{Start: size - n, Length: n}, true, nil
+	}
+
+	start, err := parseDigits(first)
+	if err != nil {
+		return Range{}, false, err
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = parseDigits(last)
+		if err != nil {
+			return Range{}, false, err
+		}
+		if end < start {
+			return Range{}, false, ErrInvalid
+		}
+		end = min(end, size-1)
+	}
+
+	if start >= size {
+		return Range{}, false, nil
+	}
+
+	return Range{Start: start, Length: end - start + 1}, true, nil
+}
+
+func parseDigits(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrInvalid
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+
+	return n, nil
+}
+
+// ifRangeMatches evaluates If-Range against ETag and Last-Modified of
+// the response, RFC 9110 section 13.1.5
+func ifRangeMatches(req *request.Request, h headers.Headers) bool {
+	ifRange, ok := req.Headers.GetString("If-Range")
+	if !ok {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) {
+		etag, ok := h.GetString("ETag")
+		return ok && etag == ifRange
+	}
+
+	if strings.HasPrefix(ifRange, "W/") {
+		return false
+	}
+
+	lastModified, ok := h.GetString("Last-Modified")
+	return ok && lastModified == ifRange
+}
+
+// ServeContent writes content of size bytes honoring Range and If-Range
+// request headers. h should contain Content-Type and validators, it is
+// completed with Content-Length, Content-Range and Accept-Ranges
+func ServeContent(w response.Writer, req *request.Request, h headers.Headers, content io.ReadSeeker, size int64) error {
+	h.Set("Accept-Ranges", unit)
+	setContentLength(h, size)
+
+	rangeHeader, ok := req.Headers.GetString("Range")
+	method := req.RequestLine.Method
+
+	if !ok || (method != "GET" && method != "HEAD") || !ifRangeMatches(req, h) {
+		return serveRanges(w, req, h, content, size, []Range{{Start: 0, Length: size}}, response.StatusOK)
+	}
+
+	ranges, err := Parse(rangeHeader, size)
+
+	switch {
+	case errors.Is(err, ErrUnsatisfiable):
+		setContentLength(h, 0)
+		h.Set("Content-Range", fmt.Sprintf("%s */%d", unit, size))
+		if err := w.WriteStatusLine(response.StatusRangeNotSatisfiable); err != nil {
+			return err
+		}
+		return w.WriteHeaders(h)
+	case err != nil:
+		ranges = []Range{{Start: 0, Length: size}}
+		return serveRanges(w, req, h, content, size, ranges, response.StatusOK)
+	default:
+		return serveRanges(w, req, h, content, size, ranges, response.StatusPartialContent)
+	}
+}
+
+func setContentLength(h headers.Headers, n int64) {
+	if _, ok := h.GetString("Content-Length"); ok {
+		h.Change("Content-Length", strconv.FormatInt(n, 10))
+	} else {
+		h.Set("Content-Length", strconv.FormatInt(n, 10))
+	}
+}
+
+func serveRanges(w response.Writer, req *request.Request, h headers.Headers, content io.ReadSeeker, size int64, ranges []Range, statusCode int) error {
+	var parts []string
+	var boundary string
+
+	switch {
+	case statusCode == response.StatusPartialContent && len(ranges) == 1:
+		setContentLength(h, ranges[0].Length)
+		h.Set("Content-Range", ranges[0].ContentRange(size))
+	case statusCode == response.StatusPartialContent:
+		boundary = newBoundary()
+		contentType, _ := h.GetString("Content-Type")
+		parts, size = multipartHeaders(ranges, boundary, contentType, size)
+		setContentLength(h, size)
+		h.Change("Content-Type", "multipart/byteranges; boundary="+boundary)
+	}
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	if req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+
+	for i, r := range ranges {
+		if parts != nil {
+			if err := w.WriteBody([]byte(parts[i])); err != nil {
+				return err
+			}
+		}
+
+		if err := copyRange(w, content, r); err != nil {
+			return err
+		}
+	}
+
+	if parts != nil {
+		return w.WriteBody([]byte(parts[len(parts)-1]))
+	}
+
+	return nil
+}
+
+// multipartHeaders returns delimiter and headers preceding every part,
+// the last element closes the body. Total body length is returned too
+func multipartHeaders(ranges []Range, boundary, contentType string, size int64) ([]string, int64) {
+	parts := make([]string, 0, len(ranges)+1)
+	total := int64(0)
+
+	for i, r := range ranges {
+		part := ""
+		if i > 0 {
+			part = response.CRLF
+		}
+		part += "--" + boundary + response.CRLF
+		if contentType != "" {
+			part += "Content-Type: " + contentType + response.CRLF
+		}
+		part += "Content-Range: " + r.ContentRange(size) + response.CRLF + response.CRLF
+
+		parts = append(parts, part)
+		total += int64(len(part)) + r.Length
+	}
+
+	closing := response.CRLF + "--" + boundary + "--" + response.CRLF
+	parts = append(parts, closing)
+	total += int64(len(closing))
+
+	return parts, total
+}
+
+func copyRange(w response.Writer, content io.ReadSeeker, r Range) error {
+	if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+		return err
+	}
+
+	n, err := w.ReadFrom(io.LimitReader(content, r.Length))
+	if err != nil {
+		return err
+	}
+	if n != r.Length {
+		return fmt.Errorf("failed to write all range data: written - %d, range len - %d", n, r.Length)
+	}
+
+	return nil
+}
+
+func newBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
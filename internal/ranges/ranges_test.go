@@ -0,0 +1,152 @@
+package ranges
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("ok, ranges", func(t *testing.T) {
+		cases := map[string][]Range{
+			"bytes=0-499":            {{0, 500}},
+			"bytes=500-999":          {{500, 500}},
+			"bytes=9500-":            {{9500, 500}},
+			"bytes=-500":             {{9500, 500}},
+			"bytes=-20000":           {{0, 10000}},
+			"bytes=9990-20000":       {{9990, 10}},
+			"bytes=0-0, -1":          {{0, 1}, {9999, 1}},
+			"bytes= 0-9 , 20-29":     {{0, 10}, {20, 10}},
+			"bytes=0-9,20000-,30-39": {{0, 10}, {30, 10}},
+		}
+		for header, want := range cases {
+			got, err := Parse(header, 10000)
+			require.NoError(t, err, header)
+			assert.Equal(t, want, got, header)
+		}
+	})
+	t.Run("fail, unsatisfiable", func(t *testing.T) {
+		for _, header := range []string{"bytes=10000-", "bytes=20000-30000", "bytes=-0"} {
+			_, err := Parse(header, 10000)
+			assert.ErrorIs(t, err, ErrUnsatisfiable, header)
+		}
+		_, err := Parse("bytes=-5", 0)
+		assert.ErrorIs(t, err, ErrUnsatisfiable)
+	})
+	t.Run("fail, invalid", func(t *testing.T) {
+		for _, header := range []string{
+			"items=0-1",
+			"bytes=5-1",
+			"bytes=a-b",
+			"bytes=1",
+			"bytes=+1-2",
+			"bytes=-",
+			"bytes=",
+			"bytes= , ,",
+			"bytes=0-9999,0-9999",
+			"bytes=" + strings.Repeat("0-0,", 101),
+		} {
+			_, err := Parse(header, 10000)
+			assert.ErrorIs(t, err, ErrInvalid, header)
+		}
+	})
+}
+
+func TestServeContent(t *testing.T) {
+	content := "0123456789abcdefghij"
+
+	serve := func(t *testing.T, method string, requestHeaders ...string) string {
+		t.Helper()
+		raw := method + " /video HTTP/1.1\r\nHost: localhost\r\n"
+		for _, h := range requestHeaders {
+			raw += h + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "video/mp4")
+		h.Set("ETag", `"v1"`)
+		h.Set("Last-Modified", "Tue, 15 Nov 1994 08:12:31 GMT")
+
+		buf := &bytes.Buffer{}
+		err = ServeContent(response.NewWriter(buf), req, h, strings.NewReader(content), int64(len(content)))
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	t.Run("ok, whole content", func(t *testing.T) {
+		resp := serve(t, "GET")
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, resp, "accept-ranges: bytes\r\n")
+		assert.Contains(t, resp, "content-length: 20\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"+content))
+	})
+	t.Run("ok, single range", func(t *testing.T) {
+		resp := serve(t, "GET", "Range: bytes=10-14")
+		assert.Contains(t, resp, "HTTP/1.1 206 Partial Content\r\n")
+		assert.Contains(t, resp, "content-range: bytes 10-14/20\r\n")
+		assert.Contains(t, resp, "content-length: 5\r\n")
+		assert.Contains(t, resp, "content-type: video/mp4\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\nabcde"))
+	})
+	t.Run("ok, multiple ranges", func(t *testing.T) {
+		resp := serve(t, "GET", "Range: bytes=0-1, -2")
+		assert.Contains(t, resp, "HTTP/1.1 206 Partial Content\r\n")
+
+		head, body, ok := strings.Cut(resp, "\r\n\r\n")
+		require.True(t, ok)
+
+		var boundary string
+		for _, line := range strings.Split(head, "\r\n") {
+			if v, ok := strings.CutPrefix(line, "content-type: multipart/byteranges; boundary="); ok {
+				boundary = v
+			}
+		}
+		require.NotEmpty(t, boundary)
+		assert.Contains(t, head+"\r\n", "content-length: "+strconv.Itoa(len(body))+"\r\n")
+
+		want := "--" + boundary + "\r\n" +
+			"Content-Type: video/mp4\r\n" +
+			"Content-Range: bytes 0-1/20\r\n\r\n" +
+			"01\r\n" +
+			"--" + boundary + "\r\n" +
+			"Content-Type: video/mp4\r\n" +
+			"Content-Range: bytes 18-19/20\r\n\r\n" +
+			"ij\r\n" +
+			"--" + boundary + "--\r\n"
+		assert.Equal(t, want, body)
+	})
+	t.Run("ok, head with range", func(t *testing.T) {
+		resp := serve(t, "HEAD", "Range: bytes=0-4")
+		assert.Contains(t, resp, "content-length: 5\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+	})
+	t.Run("ok, unsatisfiable", func(t *testing.T) {
+		resp := serve(t, "GET", "Range: bytes=30-")
+		assert.Contains(t, resp, "HTTP/1.1 416 Range Not Satisfiable\r\n")
+		assert.Contains(t, resp, "content-range: bytes */20\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+	})
+	t.Run("ok, invalid range ignored", func(t *testing.T) {
+		resp := serve(t, "GET", "Range: bytes=5-1")
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+
+		resp = serve(t, "GET", "Range: bytes=,")
+		assert.Contains(t, resp, "HTTP/1.1 200 OK\r\n")
+	})
+	t.Run("ok, if-range", func(t *testing.T) {
+		assert.Contains(t, serve(t, "GET", "Range: bytes=0-1", `If-Range: "v1"`), "206 Partial Content")
+		assert.Contains(t, serve(t, "GET", "Range: bytes=0-1", `If-Range: "v2"`), "200 OK")
+		assert.Contains(t, serve(t, "GET", "Range: bytes=0-1", `If-Range: W/"v1"`), "200 OK")
+		assert.Contains(t, serve(t, "GET", "Range: bytes=0-1", "If-Range: Tue, 15 Nov 1994 08:12:31 GMT"), "206 Partial Content")
+		assert.Contains(t, serve(t, "GET", "Range: bytes=0-1", "If-Range: Wed, 16 Nov 1994 08:12:31 GMT"), "200 OK")
+	})
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/auth"
+	"github.com/SSL0/http-impl/internal/request"
+)
+
+// ipv6PrefixBits is the prefix a single IPv6 client usually gets, so
+// clients can't bypass the limit by changing address inside of it
+const ipv6PrefixBits = 64
+
+// KeyFunc returns bucket key of the request, requests with empty key are
+// not limited
+type KeyFunc func(req *request.Request) string
+
+// ByIP keys requests by client IP. Forwarded and X-Forwarded-For are
+// followed only through proxies in trusted networks, the first address
+// which is not trusted is the client
+func ByIP(trusted ...netip.Prefix) KeyFunc {
+	return func(req *request.Request) string {
+		addr, ok := parseNode(req.RemoteAddr)
+		if !ok {
+			return req.RemoteAddr
+		}
+
+		hops := forwardedFor(req)
+		for isTrusted(addr, trusted) && len(hops) > 0 {
+			hop := hops[len(hops)-1]
+			hops = hops[:len(hops)-1]
+
+			if addr, ok = parseNode(hop); !ok {
+				// obfuscated identifier or "unknown", RFC 7239 section 6
+				return hop
+			}
+		}
+
+		return ipKey(addr)
+	}
+}
+
+// ByPrincipal keys requests by authenticated principal, anonymous requests
+// are keyed by fallback
+func ByPrincipal(fallback KeyFunc) KeyFunc {
+	return func(req *request.Request) string {
+		if p, ok := auth.PrincipalFrom(req); ok && p.Name != "" {
+			return "principal:" + p.Scheme + ":" + p.Name
+		}
+		return fallback(req)
+	}
+}
+
+// forwardedFor returns client and proxy addresses added by proxies, the
+// nearest proxy is the last. Forwarded is preferred over X-Forwarded-For
+func forwardedFor(req *request.Request) []string {
+	hops := []string{}
+
+	if _, ok := req.Headers.GetString("Forwarded"); ok {
+		for _, e := range req.Headers.GetElements("Forwarded") {
+			if node, ok := e.Params["for"]; ok {
+				hops = append(hops, node)
+			}
+		}
+		return hops
+	}
+
+	return req.Headers.GetList("X-Forwarded-For")
+}
+
+// parseNode parses IP with optional port, IPv6 may be in brackets
+func parseNode(node string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	return slices.ContainsFunc(trusted, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+func ipKey(addr netip.Addr) string {
+	if addr.Is6() {
+		prefix, _ := addr.Prefix(ipv6PrefixBits)
+		return "ip:" + prefix.String()
+	}
+	return "ip:" + addr.String()
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+type Options struct {
+	Store *Store
+	Limit Limit
+	// Key is ByIP without trusted proxies by default
+	Key KeyFunc
+	// Name separates buckets of middlewares sharing the store, e.g. a
+	// stricter limit of one route. Middlewares with the same name share
+	// buckets and must have the same Limit
+	Name string
+}
+
+// Middleware answers 429 with Retry-After when client runs out of tokens,
+// handler isn't called then. RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset are sent with every limited response
+func Middleware(opts Options) server.Middleware {
+	if opts.Store == nil {
+		panic("rate limit needs a store")
+	}
+	if err := opts.Limit.Validate(); err != nil {
+		panic(err)
+	}
+	if opts.Key == nil {
+		opts.Key = ByIP()
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			key := opts.Key(req)
+			if key == "" {
+				next(w, req)
+				return
+			}
+
+			result := opts.Store.Take(opts.Name+"|"+key, opts.Limit)
+			if !result.Allowed {
+				slog.Info("rate limit exceeded", "key", key, "limiter", opts.Name)
+				writeTooManyRequests(w, req, result)
+				return
+			}
+
+			ow := response.Observe(w)
+			ow.OnHeaders(func(_ int, h headers.Headers) {
+				setRateLimitHeaders(h, result)
+			})
+			next(ow, req)
+		}
+	}
+}
+
+// setRateLimitHeaders sets fields of draft-ietf-httpapi-ratelimit-headers
+func setRateLimitHeaders(h headers.Headers, r Result) {
+	h.Delete("RateLimit-Limit")
+	h.Delete("RateLimit-Remaining")
+	h.Delete("RateLimit-Reset")
+
+	h.Set("RateLimit-Limit", strconv.Itoa(r.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(r.Reset)))
+}
+
+func writeTooManyRequests(w response.Writer, req *request.Request, r Result) {
+	ow := response.Observe(w)
+	ow.OnHeaders(func(_ int, h headers.Headers) {
+		setRateLimitHeaders(h, r)
+		h.Delete("Retry-After")
+		h.Set("Retry-After", strconv.Itoa(seconds(r.RetryAfter)))
+	})
+	server.RenderError(ow, req, server.NewHandlerError(response.StatusTooManyRequests, ""))
+}
+
+// seconds rounds d up, so client retrying after it gets a token
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/auth"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, remoteAddr string, extra ...string) *request.Request {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func serve(h server.HandlerFunc, req *request.Request) string {
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func okHandler(w response.Writer, req *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	limit := Limit{Requests: 2, Period: time.Second, Burst: 3}
+
+	t.Run("ok, burst then rate", func(t *testing.T) {
+		for remaining := 2; remaining >= 0; remaining-- {
+			r := s.Take("a", limit)
+			assert.True(t, r.Allowed)
+			assert.Equal(t, 3, r.Limit)
+			assert.Equal(t, remaining, r.Remaining)
+		}
+
+		r := s.Take("a", limit)
+		assert.False(t, r.Allowed)
+		assert.Equal(t, 500*time.Millisecond, r.RetryAfter)
+		assert.Equal(t, 1500*time.Millisecond, r.Reset)
+
+		// other keys have their own buckets
+		assert.True(t, s.Take("b", limit).Allowed)
+
+		now = now.Add(500 * time.Millisecond)
+		assert.True(t, s.Take("a", limit).Allowed)
+		assert.False(t, s.Take("a", limit).Allowed)
+	})
+
+	t.Run("ok, full buckets are evicted", func(t *testing.T) {
+		s.evictIdle()
+		assert.Equal(t, 1, bucketCount(s))
+
+		now = now.Add(2 * time.Second)
+		s.evictIdle()
+		assert.Zero(t, bucketCount(s))
+	})
+}
+
+func bucketCount(s *Store) int {
+	count := 0
+	for i := range s.shards {
+		count += len(s.shards[i].buckets)
+	}
+	return count
+}
+
+func TestMiddleware(t *testing.T) {
+	store := NewStore()
+	limit := Limit{Requests: 1, Period: time.Minute, Burst: 2}
+	h := Middleware(Options{Store: store, Limit: limit})(okHandler)
+	strict := Middleware(Options{Store: store, Limit: Limit{Requests: 1, Period: time.Hour}, Name: "login"})(okHandler)
+
+	t.Run("ok, limit headers", func(t *testing.T) {
+		resp := serve(h, newRequest(t, "192.0.2.1:5000"))
+		assert.Contains(t, resp, "HTTP/1.1 200 OK")
+		assert.Contains(t, resp, "ratelimit-limit: 2\r\n")
+		assert.Contains(t, resp, "ratelimit-remaining: 1\r\n")
+		assert.Contains(t, resp, "ratelimit-reset: 60\r\n")
+	})
+
+	t.Run("fail, too many requests", func(t *testing.T) {
+		assert.Contains(t, serve(h, newRequest(t, "192.0.2.1:5001")), "HTTP/1.1 200 OK")
+
+		resp := serve(h, newRequest(t, "192.0.2.1:5002"))
+		assert.Contains(t, resp, "HTTP/1.1 429 Too Many Requests")
+		assert.Contains(t, resp, "ratelimit-remaining: 0\r\n")
+		assert.Contains(t, resp, "retry-after: 60\r\n")
+	})
+
+	t.Run("ok, other clients are not limited", func(t *testing.T) {
+		assert.Contains(t, serve(h, newRequest(t, "192.0.2.2:5000")), "HTTP/1.1 200 OK")
+	})
+
+	t.Run("ok, named limiter has own buckets", func(t *testing.T) {
+		assert.Contains(t, serve(strict, newRequest(t, "192.0.2.1:5000")), "HTTP/1.1 200 OK")
+		assert.Contains(t, serve(strict, newRequest(t, "192.0.2.1:5000")), "HTTP/1.1 429 Too Many Requests")
+	})
+
+	t.Run("ok, empty key is not limited", func(t *testing.T) {
+		unlimited := Middleware(Options{Store: store, Limit: limit, Key: func(*request.Request) string { return "" }})(okHandler)
+		for range 5 {
+			assert.Contains(t, serve(unlimited, newRequest(t, "192.0.2.1:5000")), "HTTP/1.1 200 OK")
+		}
+	})
+
+	t.Run("fail, invalid options", func(t *testing.T) {
+		for _, opts := range []Options{
+			{Limit: limit},
+			{Store: store, Limit: Limit{Period: time.Minute}},
+			{Store: store, Limit: Limit{Requests: 1}},
+			{Store: store, Limit: Limit{Requests: 10, Period: time.Nanosecond}},
+		} {
+			assert.Panics(t, func() { Middleware(opts) })
+		}
+	})
+}
+
+func TestKeys(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8:ffff::/48")}
+	byIP := ByIP(trusted...)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    []string
+		key        string
+	}{
+		{"direct client", "192.0.2.1:5000", nil, "ip:192.0.2.1"},
+		{"header of untrusted client is ignored", "192.0.2.1:5000", []string{"X-Forwarded-For: 198.51.100.1"}, "ip:192.0.2.1"},
+		{"x-forwarded-for through trusted proxies", "10.0.0.1:5000", []string{"X-Forwarded-For: 203.0.113.9, 198.51.100.1, 10.0.0.2"}, "ip:198.51.100.1"},
+		{"forwarded is preferred", "10.0.0.1:5000", []string{
+			`Forwarded: for=198.51.100.7;proto=https, for="[2001:db8:ffff::1]:4711"`,
+			"X-Forwarded-For: 203.0.113.9",
+		}, "ip:198.51.100.7"},
+		{"only trusted proxies", "10.0.0.1:5000", []string{"X-Forwarded-For: 10.0.0.3"}, "ip:10.0.0.3"},
+		{"obfuscated node", "10.0.0.1:5000", []string{"Forwarded: for=_hidden"}, "_hidden"},
+		{"ipv6 is keyed by prefix", "[2001:db8:1:2:3:4:5:6]:5000", nil, "ip:2001:db8:1:2::/64"},
+		{"ipv4 mapped ipv6", "[::ffff:192.0.2.1]:5000", nil, "ip:192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run("ok, "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.key, byIP(newRequest(t, tt.remoteAddr, tt.headers...)))
+		})
+	}
+
+	t.Run("ok, principal", func(t *testing.T) {
+		byPrincipal := ByPrincipal(byIP)
+		req := newRequest(t, "192.0.2.1:5000")
+		assert.Equal(t, "ip:192.0.2.1", byPrincipal(req))
+
+		req = auth.WithPrincipal(req, auth.Principal{Name: "alice", Scheme: "Bearer"})
+		assert.Equal(t, "principal:Bearer:alice", byPrincipal(req))
+	})
+}
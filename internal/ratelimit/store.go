@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+const shardCount = 64
+
+// Limit allows Requests per Period on average and up to Burst at once
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	// Burst is bucket size, Requests by default
+	Burst int
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// Validate checks that tokens are added, so the bucket is refilled
+func (l Limit) Validate() error {
+	if l.Requests <= 0 || l.Period <= 0 {
+		return fmt.Errorf("invalid rate limit %d per %s", l.Requests, l.Period)
+	}
+	if l.interval() <= 0 {
+		return fmt.Errorf("rate limit %d per %s is too high", l.Requests, l.Period)
+	}
+	return nil
+}
+
+// interval returns time to add one token
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result of taking a token from the bucket
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is time until the next token if request is not allowed
+	RetryAfter time.Duration
+	// Reset is time until the bucket is full again
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is refilled, it can be forgotten after that
+	full time.Time
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// Store keeps token buckets in memory. Keys are spread over shards with
+// their own locks, so clients don't wait for each other
+type Store struct {
+	seed   maphash.Seed
+	shards [shardCount]shard
+	now    func() time.Time
+}
+
+func NewStore() *Store {
+	s := &Store{seed: maphash.MakeSeed(), now: time.Now}
+	for i := range s.shards {
+		s.shards[i].buckets = map[string]*bucket{}
+	}
+	return s
+}
+
+func (s *Store) shard(key string) *shard {
+	return &s.shards[maphash.String(s.seed, key)%shardCount]
+}
+
+// Take takes a token from bucket of key if there is one
+func (s *Store) Take(key string, limit Limit) Result {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := s.now()
+	burst := limit.burst()
+	interval := limit.interval()
+
+	b, ok := sh.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		sh.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.updated)
+	b.tokens = math.Min(burst, b.tokens+float64(elapsed)/float64(interval))
+	b.updated = now
+
+	result := Result{Limit: int(burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(interval))
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((burst - b.tokens) * float64(interval))
+	b.full = now.Add(result.Reset)
+	return result
+}
+
+// Evict forgets full buckets every interval until ctx is done, they are
+// the same as new ones
+func (s *Store) Evict(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.evictIdle()
+		}
+	}
+}
+
+func (s *Store) evictIdle() {
+	now := s.now()
+
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key, b := range sh.buckets {
+			if !now.Before(b.full) {
+				delete(sh.buckets, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
@@ -0,0 +1,46 @@
+package request
+
+import (
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/headers"
+)
+
+// Cookie is a name-value pair sent by client in Cookie field
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies parses Cookie field, RFC 6265 section 5.4. Pairs with invalid
+// names are skipped, quotes around values are removed
+func (r *Request) Cookies() []Cookie {
+	cookies := []Cookie{}
+
+	for _, line := range r.Headers.Values("Cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !headers.IsToken(name) {
+				continue
+			}
+
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			cookies = append(cookies, Cookie{Name: name, Value: value})
+		}
+	}
+
+	return cookies
+}
+
+// Cookie returns the first cookie with name. Clients send cookies with
+// more specific paths first
+func (r *Request) Cookie(name string) (Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Cookie{}, false
+}
@@ -0,0 +1,43 @@
+package request
+
+import "strings"
+
+const spiffeScheme = "spiffe"
+
+// PeerIdentity describes a client authenticated with a verified TLS
+// certificate
+type PeerIdentity struct {
+	Subject    string
+	CommonName string
+	DNSNames   []string
+	Emails     []string
+	URIs       []string
+	// SPIFFEID is the first spiffe:// URI SAN, empty if there is none
+	SPIFFEID string
+}
+
+// PeerIdentity returns identity from the verified client certificate or
+// nil if client did not present one
+func (r *Request) PeerIdentity() *PeerIdentity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	leaf := r.TLS.VerifiedChains[0][0]
+	id := &PeerIdentity{
+		Subject:    leaf.Subject.String(),
+		CommonName: leaf.Subject.CommonName,
+		DNSNames:   leaf.DNSNames,
+		Emails:     leaf.EmailAddresses,
+		URIs:       []string{},
+	}
+
+	for _, u := range leaf.URIs {
+		id.URIs = append(id.URIs, u.String())
+		if id.SPIFFEID == "" && strings.EqualFold(u.Scheme, spiffeScheme) {
+			id.SPIFFEID = u.String()
+		}
+	}
+
+	return id
+}
@@ -2,6 +2,8 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -36,7 +38,17 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	state       parserState
+	// RemoteAddr and LocalAddr are set by server, with PROXY protocol
+	// they are the original client and destination addresses
+	RemoteAddr string
+	LocalAddr  string
+	// TLS is nil for plain connections. It holds negotiated version,
+	// cipher suite, SNI server name and peer certificates
+	TLS *tls.ConnectionState
+	// Params are captured from path pattern by router
+	Params map[string]string
+	ctx    context.Context
+	state  parserState
 }
 
 func NewRequest() *Request {
@@ -46,10 +58,32 @@ func NewRequest() *Request {
 		state:   stateRequestLine}
 }
 
+// Context is cancelled when client disconnects or server shuts down
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns shallow copy of r with ctx
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
 func (r *Request) done() bool {
 	return r.state == stateDone
 }
 
+// RequestFromReader parses request from reader. On error the partially
+// parsed request is returned too, so error response can take into account
+// headers received so far
 func RequestFromReader(reader io.Reader) (*Request, error) {
 	buf := make([]byte, bufferSize)
 	bufLen := 0
@@ -68,14 +102,14 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		}
 
 		if err != nil {
-			return nil, err
+			return req, err
 		}
 
 		bufLen += readedBytes
 		parsedBytes, err := req.parse(buf[:bufLen])
 
 		if err != nil {
-			return nil, err
+			return req, err
 		}
 
 		copy(buf, buf[parsedBytes:bufLen])
@@ -83,7 +117,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 	}
 
 	if req.Headers.GetInt("Content-Length", 0) != len(req.Body) {
-		return nil, fmt.Errorf("body not equal content-length")
+		return req, fmt.Errorf("body not equal content-length")
 	}
 
 	return req, nil
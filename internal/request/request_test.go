@@ -283,3 +283,48 @@ func TestBodyParse(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestPartialRequestOnError(t *testing.T) {
+	t.Run("ok, headers parsed before error are kept", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "GET / HTTP/1.1\r\nAccept: application/json\r\nH@st: localhost\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err := RequestFromReader(reader)
+		require.Error(t, err)
+		require.NotNil(t, r)
+		assert.Equal(t, "GET", r.RequestLine.Method)
+		v, ok := r.Headers.GetString("Accept")
+		assert.True(t, ok)
+		assert.Equal(t, "application/json", v)
+	})
+}
+
+func TestCookies(t *testing.T) {
+	t.Run("ok, pairs", func(t *testing.T) {
+		r, err := RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: session=abc123; theme=\"dark\";  empty=; lang=ru-RU\r\n\r\n", numBytesPerRead: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []Cookie{
+			{Name: "session", Value: "abc123"},
+			{Name: "theme", Value: "dark"},
+			{Name: "empty", Value: ""},
+			{Name: "lang", Value: "ru-RU"},
+		}, r.Cookies())
+
+		c, ok := r.Cookie("theme")
+		assert.True(t, ok)
+		assert.Equal(t, "dark", c.Value)
+	})
+	t.Run("ok, invalid pairs are skipped", func(t *testing.T) {
+		r, err := RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: noequals; bad name=1; =2; ok=1\r\n\r\n", numBytesPerRead: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []Cookie{{Name: "ok", Value: "1"}}, r.Cookies())
+	})
+	t.Run("fail, no cookie", func(t *testing.T) {
+		r, err := RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", numBytesPerRead: 3})
+		require.NoError(t, err)
+		assert.Empty(t, r.Cookies())
+		_, ok := r.Cookie("session")
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,39 @@
+package response
+
+import (
+	"fmt"
+)
+
+// ChunkedWriter frames data written to it with chunked transfer coding,
+// RFC 9112 section 7.1. Headers must contain Transfer-Encoding: chunked
+// and no Content-Length
+type ChunkedWriter struct {
+	writer Writer
+}
+
+func NewChunkedWriter(w Writer) *ChunkedWriter {
+	return &ChunkedWriter{writer: w}
+}
+
+func (c *ChunkedWriter) Write(p []byte) (int, error) {
+	// empty chunk would terminate the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	chunk := make([]byte, 0, len(p)+20)
+	chunk = fmt.Appendf(chunk, "%x%s", len(p), CRLF)
+	chunk = append(chunk, p...)
+	chunk = append(chunk, CRLF...)
+
+	if err := c.writer.WriteBody(chunk); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close writes the last chunk
+func (c *ChunkedWriter) Close() error {
+	return c.writer.WriteBody([]byte("0" + CRLF + CRLF))
+}
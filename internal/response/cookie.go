@@ -0,0 +1,155 @@
+package response
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+)
+
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute, browsers treat it as Lax
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone requires Secure
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// Cookie describes Set-Cookie field, RFC 6265 section 4.1
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain string
+	Path   string
+	// Expires is omitted when zero
+	Expires time.Time
+	// MaxAge is omitted when zero, negative value deletes cookie with
+	// Max-Age=0
+	MaxAge int
+
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned stores cookie per top level site (CHIPS), requires Secure
+	Partitioned bool
+}
+
+// Validate checks name and attribute values and requirements of cookie
+// prefixes and attributes browsers would reject the cookie for
+func (c Cookie) Validate() error {
+	if !headers.IsToken(c.Name) {
+		return fmt.Errorf("invalid cookie name: %q", c.Name)
+	}
+	if !validCookieValue(c.Value) {
+		return fmt.Errorf("invalid value of cookie %s", c.Name)
+	}
+	if strings.ContainsAny(c.Domain+c.Path, ";\r\n\x00") {
+		return fmt.Errorf("invalid domain or path of cookie %s", c.Name)
+	}
+
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return fmt.Errorf("cookie %s with SameSite=None or Partitioned must be Secure", c.Name)
+	}
+
+	// cookie prefixes, RFC 6265bis section 4.1.3
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		return fmt.Errorf("cookie %s must be Secure", c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Path != "/" || c.Domain != "") {
+		return fmt.Errorf("cookie %s must be Secure with Path=/ and no Domain", c.Name)
+	}
+
+	return nil
+}
+
+// String returns Set-Cookie field value, c should be valid
+func (c Cookie) String() string {
+	b := strings.Builder{}
+	b.WriteString(c.Name + "=" + c.Value)
+
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + headers.FormatTime(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+
+	return b.String()
+}
+
+// SetCookie adds Set-Cookie field line to h, every cookie is sent in a
+// separate line
+func SetCookie(h headers.Headers, c Cookie) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	h.Set("Set-Cookie", c.String())
+	return nil
+}
+
+// DeleteCookie adds Set-Cookie field line expiring cookie with name. Path
+// and domain have to be the same as when the cookie was set
+func DeleteCookie(h headers.Headers, name, path, domain string) error {
+	return SetCookie(h, Cookie{
+		Name:    name,
+		Path:    path,
+		Domain:  domain,
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+		Secure:  strings.HasPrefix(name, "__Secure-") || strings.HasPrefix(name, "__Host-"),
+	})
+}
+
+// validCookieValue checks cookie-value, RFC 6265 section 4.1.1
+func validCookieValue(v string) bool {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
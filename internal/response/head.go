@@ -0,0 +1,39 @@
+package response
+
+import (
+	"io"
+
+	"github.com/SSL0/http-impl/internal/headers"
+)
+
+// headWriter drops body of response to HEAD request, status line and
+// headers are the same as for GET, RFC 9110 section 9.3.2
+type headWriter struct {
+	Writer
+}
+
+// DiscardBody wraps w so handlers can write response to HEAD as for GET
+func DiscardBody(w Writer) Writer {
+	if _, ok := w.(headWriter); ok {
+		return w
+	}
+	return headWriter{Writer: w}
+}
+
+func (w headWriter) WriteBody(p []byte) error {
+	return nil
+}
+
+// ReadFrom consumes r, so callers checking written length see all of it
+func (w headWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(io.Discard, r)
+}
+
+// GetNoContentHeaders returns default headers of response which never has
+// content, e.g. 204 or 304, where Content-Length and Content-Type are not
+// sent
+func GetNoContentHeaders() headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	return h
+}
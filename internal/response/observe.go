@@ -0,0 +1,66 @@
+package response
+
+import (
+	"io"
+
+	"github.com/SSL0/http-impl/internal/headers"
+)
+
+// ObservedWriter wraps Writer and records what was written through it.
+// Hooks registered with OnHeaders can change headers before they are sent
+type ObservedWriter struct {
+	Writer
+	StatusCode   int
+	Headers      headers.Headers
+	BytesWritten int
+
+	headerHooks []func(statusCode int, h headers.Headers)
+}
+
+func Observe(w Writer) *ObservedWriter {
+	return &ObservedWriter{Writer: w}
+}
+
+// OnHeaders registers hook called right before headers are written
+func (o *ObservedWriter) OnHeaders(hook func(statusCode int, h headers.Headers)) {
+	o.headerHooks = append(o.headerHooks, hook)
+}
+
+// WroteStatusLine reports whether response has been started
+func (o *ObservedWriter) WroteStatusLine() bool {
+	return o.StatusCode != 0
+}
+
+func (o *ObservedWriter) WriteStatusLine(statusCode int) error {
+	if err := o.Writer.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	o.StatusCode = statusCode
+	return nil
+}
+
+func (o *ObservedWriter) WriteHeaders(h headers.Headers) error {
+	for _, hook := range o.headerHooks {
+		hook(o.StatusCode, h)
+	}
+
+	if err := o.Writer.WriteHeaders(h); err != nil {
+		return err
+	}
+	o.Headers = h
+	return nil
+}
+
+func (o *ObservedWriter) WriteBody(p []byte) error {
+	if err := o.Writer.WriteBody(p); err != nil {
+		return err
+	}
+	o.BytesWritten += len(p)
+	return nil
+}
+
+func (o *ObservedWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := o.Writer.ReadFrom(r)
+	o.BytesWritten += int(n)
+	return n, err
+}
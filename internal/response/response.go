@@ -11,8 +11,21 @@ import (
 
 const (
 	StatusOK                  = 200
+	StatusNoContent           = 204
+	StatusPartialContent      = 206
+	StatusMovedPermanently    = 301
+	StatusNotModified         = 304
 	StatusBadRequset          = 400
+	StatusUnauthorized        = 401
+	StatusForbidden           = 403
 	StatusNotFound            = 404
+	StatusMethodNotAllowed    = 405
+	StatusNotAcceptable       = 406
+	StatusPreconditionFailed  = 412
+	StatusContentTooLarge     = 413
+	StatusUnsupportedMedia    = 415
+	StatusRangeNotSatisfiable = 416
+	StatusTooManyRequests     = 429
 	StatusInternalServerError = 500
 )
 
@@ -25,10 +38,36 @@ func StatusText(code int) string {
 	switch code {
 	case StatusOK:
 		return "OK"
+	case StatusNoContent:
+		return "No Content"
+	case StatusPartialContent:
+		return "Partial Content"
+	case StatusMovedPermanently:
+		return "Moved Permanently"
+	case StatusNotModified:
+		return "Not Modified"
 	case StatusBadRequset:
 		return "Bad Request"
+	case StatusUnauthorized:
+		return "Unauthorized"
+	case StatusForbidden:
+		return "Forbidden"
 	case StatusNotFound:
 		return "Not Found"
+	case StatusMethodNotAllowed:
+		return "Method Not Allowed"
+	case StatusNotAcceptable:
+		return "Not Acceptable"
+	case StatusPreconditionFailed:
+		return "Precondition Failed"
+	case StatusContentTooLarge:
+		return "Content Too Large"
+	case StatusUnsupportedMedia:
+		return "Unsupported Media Type"
+	case StatusRangeNotSatisfiable:
+		return "Range Not Satisfiable"
+	case StatusTooManyRequests:
+		return "Too Many Requests"
 	case StatusInternalServerError:
 		return "Internal Server Error"
 	default:
@@ -44,16 +83,26 @@ const (
 	WritingBody       writerState = iota
 )
 
-type Writer struct {
+// Writer writes response in order: status line, headers, body. It is an
+// interface so middlewares can wrap it
+type Writer interface {
+	WriteStatusLine(statusCode int) error
+	WriteHeaders(headers headers.Headers) error
+	WriteBody(p []byte) error
+	// ReadFrom writes body from r without buffering it in memory
+	ReadFrom(r io.Reader) (int64, error)
+}
+
+type streamWriter struct {
 	writer io.Writer
 	state  writerState
 }
 
-func NewWriter(w io.Writer) *Writer {
-	return &Writer{writer: w, state: WritingStatusLine}
+func NewWriter(w io.Writer) Writer {
+	return &streamWriter{writer: w, state: WritingStatusLine}
 }
 
-func (w *Writer) WriteStatusLine(statusCode int) error {
+func (w *streamWriter) WriteStatusLine(statusCode int) error {
 	if w.state != WritingStatusLine {
 		return fmt.Errorf("failed to write status line, writer state is different")
 	}
@@ -65,7 +114,7 @@ func (w *Writer) WriteStatusLine(statusCode int) error {
 	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *streamWriter) WriteHeaders(headers headers.Headers) error {
 	if w.state != WritingHeaders {
 		return fmt.Errorf("failed to write headers, writer state is different")
 	}
@@ -79,7 +128,7 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	return nil
 }
 
-func (w *Writer) WriteBody(p []byte) error {
+func (w *streamWriter) WriteBody(p []byte) error {
 	if w.state != WritingBody {
 		return fmt.Errorf("failed to write body, writer state is different")
 	}
@@ -93,6 +142,22 @@ func (w *Writer) WriteBody(p []byte) error {
 	return nil
 }
 
+// ReadFrom lets the kernel move the data when possible: the underlying
+// *net.TCPConn uses sendfile(2) for *os.File and splice(2) for TCP
+// sources, also wrapped in *io.LimitedReader. Writers without ReadFrom,
+// e.g. TLS connections, fall back to copying through user space
+func (w *streamWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.state != WritingBody {
+		return 0, fmt.Errorf("failed to write body, writer state is different")
+	}
+
+	if rf, ok := w.writer.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+
+	return io.Copy(w.writer, r)
+}
+
 func writeStatusLine(w io.Writer, statusCode int) error {
 	reasonPhrase := StatusText(statusCode)
 
@@ -143,7 +208,7 @@ func writeBody(w io.Writer, body []byte) error {
 	n, err := w.Write(body)
 
 	if err != nil {
-		return nil
+		return err
 	}
 	slog.Info("wrote body", "data", body)
 
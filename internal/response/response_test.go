@@ -0,0 +1,200 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type readerFromRecorder struct {
+	bytes.Buffer
+	source io.Reader
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.source = src
+	return r.Buffer.ReadFrom(src)
+}
+
+// plainWriter hides ReadFrom of the buffer, like tls.Conn does not have one
+type plainWriter struct {
+	buf bytes.Buffer
+}
+
+func (p *plainWriter) Write(data []byte) (int, error) {
+	return p.buf.Write(data)
+}
+
+func writeHead(t *testing.T, w Writer, contentLength int) {
+	t.Helper()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(contentLength)))
+}
+
+func TestReadFrom(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "artifact.bin")
+	content := strings.Repeat("0123456789", 10000)
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+
+	t.Run("ok, file is passed to underlying ReaderFrom", func(t *testing.T) {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+
+		dst := &readerFromRecorder{}
+		w := NewWriter(dst)
+		writeHead(t, w, len(content))
+
+		limited := io.LimitReader(f, 10)
+		n, err := w.ReadFrom(limited)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), n)
+		assert.Same(t, limited, dst.source)
+		assert.True(t, strings.HasSuffix(dst.String(), "\r\n\r\n0123456789"))
+	})
+	t.Run("ok, fallback to copy", func(t *testing.T) {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+
+		dst := &plainWriter{}
+		w := Observe(NewWriter(dst))
+		writeHead(t, w, len(content))
+
+		n, err := w.ReadFrom(f)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), n)
+		assert.Equal(t, len(content), w.BytesWritten)
+		assert.True(t, strings.HasSuffix(dst.buf.String(), content))
+	})
+	t.Run("ok, file over tcp connection", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		received := make(chan string)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				received <- ""
+				return
+			}
+			defer conn.Close()
+			data, _ := io.ReadAll(conn)
+			received <- string(data)
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+
+		w := NewWriter(conn)
+		writeHead(t, w, len(content))
+		n, err := w.ReadFrom(f)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), n)
+		conn.Close()
+
+		assert.True(t, strings.HasSuffix(<-received, "\r\n\r\n"+content))
+	})
+	t.Run("fail, body before headers", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{})
+		_, err := w.ReadFrom(strings.NewReader("body"))
+		require.Error(t, err)
+	})
+}
+
+func TestChunkedWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+
+	cw := NewChunkedWriter(w)
+	_, err := cw.Write([]byte("hello, "))
+	require.NoError(t, err)
+	_, err = cw.Write(nil)
+	require.NoError(t, err)
+	_, err = cw.Write([]byte(strings.Repeat("x", 20)))
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+
+	_, body, ok := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, ok)
+	assert.Equal(t, "7\r\nhello, \r\n14\r\n"+strings.Repeat("x", 20)+"\r\n0\r\n\r\n", body)
+}
+
+func TestDiscardBody(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := DiscardBody(NewWriter(buf))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	require.NoError(t, w.WriteBody([]byte("hello")))
+	n, err := w.ReadFrom(strings.NewReader("world"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+
+	assert.Contains(t, buf.String(), "content-length: 10\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"), buf.String())
+}
+
+func TestSetCookie(t *testing.T) {
+	t.Run("ok, separate field lines", func(t *testing.T) {
+		h := GetDefaultHeaders(0)
+		require.NoError(t, SetCookie(h, Cookie{
+			Name:     "session",
+			Value:    "abc",
+			Path:     "/",
+			Expires:  time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+			MaxAge:   3600,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: SameSiteLax,
+		}))
+		require.NoError(t, SetCookie(h, Cookie{Name: "__Host-embed", Value: "1", Path: "/", Secure: true, SameSite: SameSiteNone, Partitioned: true}))
+		require.NoError(t, DeleteCookie(h, "theme", "/", "example.com"))
+
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+
+		raw := buf.String()
+		assert.Contains(t, raw, "\r\nset-cookie: session=abc; Path=/; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Lax\r\n")
+		assert.Contains(t, raw, "\r\nset-cookie: __Host-embed=1; Path=/; Secure; SameSite=None; Partitioned\r\n")
+		assert.Contains(t, raw, "\r\nset-cookie: theme=; Domain=example.com; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0\r\n")
+		assert.Len(t, h.Values("Set-Cookie"), 3)
+	})
+	t.Run("fail, invalid cookies", func(t *testing.T) {
+		for _, c := range []Cookie{
+			{Name: "bad name", Value: "1"},
+			{Name: "a", Value: "with space"},
+			{Name: "a", Value: "semi;colon"},
+			{Name: "a", Value: "1", Path: "/; Secure"},
+			{Name: "a", Value: "1", SameSite: SameSiteNone},
+			{Name: "a", Value: "1", Partitioned: true},
+			{Name: "__Secure-a", Value: "1"},
+			{Name: "__Host-a", Value: "1", Secure: true, Path: "/app"},
+			{Name: "__Host-a", Value: "1", Secure: true, Path: "/", Domain: "example.com"},
+		} {
+			h := GetDefaultHeaders(0)
+			assert.Error(t, SetCookie(h, c), c.Name)
+			assert.Empty(t, h.Values("Set-Cookie"))
+		}
+	})
+}
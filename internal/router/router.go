@@ -0,0 +1,281 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+type segmentKind int
+
+// ordered by priority, lower kind wins when several routes match
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	host     string
+	segments []segment
+	handler  server.HandlerFunc
+}
+
+type Router struct {
+	routes   []*route
+	NotFound server.HandlerFunc
+}
+
+func New() *Router {
+	return &Router{NotFound: notFound}
+}
+
+// Handle registers handler for method and pattern. Pattern is a path like
+// /users/{id} or /static/*rest optionally prefixed with host, e.g.
+// api.example.com/users/{id} or *.example.com/
+func (r *Router) Handle(method, pattern string, h server.HandlerFunc) {
+	rt, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	rt.method = method
+	rt.handler = h
+	r.routes = append(r.routes, rt)
+}
+
+func (r *Router) Get(pattern string, h server.HandlerFunc) {
+	r.Handle("GET", pattern, h)
+}
+
+func (r *Router) Post(pattern string, h server.HandlerFunc) {
+	r.Handle("POST", pattern, h)
+}
+
+func (r *Router) Put(pattern string, h server.HandlerFunc) {
+	r.Handle("PUT", pattern, h)
+}
+
+func (r *Router) Delete(pattern string, h server.HandlerFunc) {
+	r.Handle("DELETE", pattern, h)
+}
+
+func parsePattern(pattern string) (*route, error) {
+	rt := &route{}
+
+	slash := strings.IndexByte(pattern, '/')
+	if slash == -1 {
+		return nil, fmt.Errorf("pattern has no path: %s", pattern)
+	}
+	rt.host = strings.ToLower(pattern[:slash])
+
+	parts := strings.Split(pattern[slash+1:], "/")
+	names := map[string]bool{}
+
+	for i, part := range parts {
+		seg := segment{kind: segmentLiteral, value: part}
+
+		switch {
+		case strings.HasPrefix(part, "*"):
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("wildcard must be the last segment: %s", pattern)
+			}
+			seg = segment{kind: segmentWildcard, value: part[1:]}
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			seg = segment{kind: segmentParam, value: part[1 : len(part)-1]}
+		}
+
+		if seg.kind != segmentLiteral {
+			if seg.value == "" || names[seg.value] {
+				return nil, fmt.Errorf("invalid or duplicated param name in pattern: %s", pattern)
+			}
+			names[seg.value] = true
+		}
+
+		rt.segments = append(rt.segments, seg)
+	}
+
+	return rt, nil
+}
+
+func (rt *route) matchHost(host string) bool {
+	if rt.host == "" {
+		return true
+	}
+	if strings.HasPrefix(rt.host, "*.") {
+		return strings.HasSuffix(host, rt.host[1:]) && len(host) > len(rt.host)-1
+	}
+	return rt.host == host
+}
+
+func (rt *route) matchPath(parts []string) (map[string]string, bool) {
+	params := map[string]string{}
+
+	for i, seg := range rt.segments {
+		if seg.kind == segmentWildcard {
+			params[seg.value] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case segmentLiteral:
+			if seg.value != parts[i] {
+				return nil, false
+			}
+		case segmentParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+
+	return params, len(parts) == len(rt.segments)
+}
+
+// moreSpecific reports whether rt should win over other when both match
+func (rt *route) moreSpecific(other *route) bool {
+	if (rt.host != "") != (other.host != "") {
+		return rt.host != ""
+	}
+
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		if rt.segments[i].kind != other.segments[i].kind {
+			return rt.segments[i].kind < other.segments[i].kind
+		}
+	}
+
+	return len(rt.segments) > len(other.segments)
+}
+
+func requestHost(req *request.Request) string {
+	host, _ := req.Headers.GetString("Host")
+	host = strings.ToLower(host)
+
+	if i := strings.LastIndexByte(host, ':'); i != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+
+	return host
+}
+
+func splitPath(target string) ([]string, bool) {
+	u, err := url.Parse(target)
+	if err != nil || !strings.HasPrefix(u.EscapedPath(), "/") {
+		return nil, false
+	}
+
+	parts := strings.Split(u.EscapedPath()[1:], "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = unescaped
+	}
+
+	return parts, true
+}
+
+func methodMatches(routeMethod, method string) bool {
+	return routeMethod == method || (method == "HEAD" && routeMethod == "GET")
+}
+
+// Serve dispatches request to the matching route, it is a server.HandlerFunc.
+// HEAD is handled by GET route, body written by it is dropped
+func (r *Router) Serve(w response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "HEAD" {
+		w = response.DiscardBody(w)
+	}
+
+	parts, ok := splitPath(req.RequestLine.RequestTarget)
+	if !ok {
+		r.NotFound(w, req)
+		return
+	}
+
+	host := requestHost(req)
+	method := req.RequestLine.Method
+
+	var best *route
+	var bestParams map[string]string
+	allowed := []string{}
+
+	for _, rt := range r.routes {
+		if !rt.matchHost(host) {
+			continue
+		}
+
+		params, ok := rt.matchPath(parts)
+		if !ok {
+			continue
+		}
+
+		if !slices.Contains(allowed, rt.method) {
+			allowed = append(allowed, rt.method)
+		}
+
+		if !methodMatches(rt.method, method) {
+			continue
+		}
+
+		if best == nil || rt.moreSpecific(best) {
+			best = rt
+			bestParams = params
+		}
+	}
+
+	if best != nil {
+		req.Params = bestParams
+		best.handler(w, req)
+		return
+	}
+
+	if len(allowed) == 0 {
+		r.NotFound(w, req)
+		return
+	}
+
+	if slices.Contains(allowed, "GET") && !slices.Contains(allowed, "HEAD") {
+		allowed = append(allowed, "HEAD")
+	}
+	if !slices.Contains(allowed, "OPTIONS") {
+		allowed = append(allowed, "OPTIONS")
+	}
+	slices.Sort(allowed)
+	allow := strings.Join(allowed, ", ")
+
+	if method == "OPTIONS" {
+		h := response.GetNoContentHeaders()
+		h.Set("Allow", allow)
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(h)
+		return
+	}
+
+	ow := response.Observe(w)
+	ow.OnHeaders(func(_ int, h headers.Headers) {
+		h.Set("Allow", allow)
+	})
+	server.RenderError(ow, req, server.NewHandlerError(response.StatusMethodNotAllowed, ""))
+}
+
+func notFound(w response.Writer, req *request.Request) {
+	server.RenderError(w, req, server.NewHandlerError(response.StatusNotFound, ""))
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func named(name string) func(w response.Writer, req *request.Request) {
+	return func(w response.Writer, req *request.Request) {
+		body := name
+		for _, k := range []string{"id", "rest", "file"} {
+			if v, ok := req.Params[k]; ok {
+				body += " " + k + "=" + v
+			}
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func serve(t *testing.T, r *Router, method, target, host string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	r.Serve(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func TestRouter(t *testing.T) {
+	r := New()
+	r.Get("/users", named("list"))
+	r.Post("/users", named("create"))
+	r.Get("/users/{id}", named("user"))
+	r.Get("/users/me", named("me"))
+	r.Delete("/users/{id}", named("delete"))
+	r.Get("/static/*rest", named("static"))
+	r.Get("/static/{file}", named("static-file"))
+	r.Get("api.example.com/users/{id}", named("api-user"))
+	r.Get("*.example.org/", named("wildcard-host"))
+
+	t.Run("ok, literal route", func(t *testing.T) {
+		assert.Contains(t, serve(t, r, "GET", "/users", "localhost"), "list")
+		assert.Contains(t, serve(t, r, "POST", "/users", "localhost"), "create")
+	})
+	t.Run("ok, path params", func(t *testing.T) {
+		assert.Contains(t, serve(t, r, "GET", "/users/42?full=1", "localhost"), "user id=42")
+		assert.Contains(t, serve(t, r, "DELETE", "/users/42", "localhost"), "delete id=42")
+		assert.Contains(t, serve(t, r, "GET", "/users/john%20doe", "localhost"), "user id=john doe")
+	})
+	t.Run("ok, literal wins over param", func(t *testing.T) {
+		assert.Contains(t, serve(t, r, "GET", "/users/me", "localhost"), "me")
+		assert.NotContains(t, serve(t, r, "GET", "/users/me", "localhost"), "id=")
+	})
+	t.Run("ok, wildcard", func(t *testing.T) {
+		assert.Contains(t, serve(t, r, "GET", "/static/css/main.css", "localhost"), "static rest=css/main.css")
+		assert.Contains(t, serve(t, r, "GET", "/static/main.css", "localhost"), "static-file file=main.css")
+		assert.Contains(t, serve(t, r, "GET", "/static/", "localhost"), "static rest=")
+	})
+	t.Run("ok, host routes", func(t *testing.T) {
+		assert.Contains(t, serve(t, r, "GET", "/users/7", "api.example.com:8080"), "api-user id=7")
+		assert.Contains(t, serve(t, r, "GET", "/users/7", "example.com"), "user id=7")
+		assert.Contains(t, serve(t, r, "GET", "/", "www.example.org"), "wildcard-host")
+		assert.Contains(t, serve(t, r, "GET", "/", "example.org"), "404 Not Found")
+	})
+	t.Run("ok, HEAD uses GET route", func(t *testing.T) {
+		resp := serve(t, r, "HEAD", "/users", "localhost")
+		assert.Contains(t, resp, "200 OK")
+		assert.Contains(t, resp, "content-length: 4\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"), resp)
+		assert.NotContains(t, resp, "list")
+
+		resp = serve(t, r, "HEAD", "/missing", "localhost")
+		assert.Contains(t, resp, "404 Not Found")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"), resp)
+	})
+	t.Run("ok, not found", func(t *testing.T) {
+		assert.Contains(t, serve(t, r, "GET", "/missing", "localhost"), "HTTP/1.1 404 Not Found")
+		assert.Contains(t, serve(t, r, "GET", "/users/42/posts", "localhost"), "HTTP/1.1 404 Not Found")
+	})
+	t.Run("ok, method not allowed", func(t *testing.T) {
+		resp := serve(t, r, "PUT", "/users/42", "localhost")
+		assert.Contains(t, resp, "HTTP/1.1 405 Method Not Allowed")
+		assert.Contains(t, resp, "allow: DELETE, GET, HEAD, OPTIONS\r\n")
+	})
+	t.Run("ok, automatic OPTIONS", func(t *testing.T) {
+		resp := serve(t, r, "OPTIONS", "/users", "localhost")
+		assert.Contains(t, resp, "HTTP/1.1 204 No Content")
+		assert.Contains(t, resp, "allow: GET, HEAD, OPTIONS, POST\r\n")
+		assert.NotContains(t, resp, "content-length")
+		assert.NotContains(t, resp, "content-type")
+	})
+	t.Run("ok, custom not found", func(t *testing.T) {
+		r := New()
+		r.NotFound = named("custom")
+		assert.Contains(t, serve(t, r, "GET", "/", "localhost"), "custom")
+	})
+	t.Run("fail, invalid patterns", func(t *testing.T) {
+		r := New()
+		assert.Panics(t, func() { r.Get("users", named("x")) })
+		assert.Panics(t, func() { r.Get("/static/*rest/more", named("x")) })
+		assert.Panics(t, func() { r.Get("/{id}/{id}", named("x")) })
+		assert.Panics(t, func() { r.Get("/{}", named("x")) })
+	})
+}
+
+func TestRouterErrorRenderer(t *testing.T) {
+	r := New()
+	r.Get("/users", named("list"))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := server.Serve(l, r.Serve, server.WithErrorRenderer(server.ProblemErrorRenderer))
+	defer s.Close()
+
+	send := func(method, target string) string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("ok, not found", func(t *testing.T) {
+		resp := send("GET", "/missing")
+		assert.Contains(t, resp, "HTTP/1.1 404 Not Found\r\n")
+		assert.Contains(t, resp, "content-type: application/problem+json\r\n")
+	})
+	t.Run("ok, method not allowed", func(t *testing.T) {
+		resp := send("DELETE", "/users")
+		assert.Contains(t, resp, "HTTP/1.1 405 Method Not Allowed\r\n")
+		assert.Contains(t, resp, "allow: GET, HEAD, OPTIONS\r\n")
+		assert.Contains(t, resp, "content-type: application/problem+json\r\n")
+	})
+}
@@ -0,0 +1,175 @@
+package securecookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	minSecretSize = 32
+	timestampSize = 8
+	keySize       = 32
+)
+
+var (
+	ErrInvalid = errors.New("invalid cookie value")
+	ErrExpired = errors.New("cookie value is expired")
+)
+
+var encoding = base64.RawURLEncoding
+
+type keys struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+// Codec signs or encrypts values bound to cookie name. The first secret
+// is used for new values, the rest are accepted so secrets can be rotated
+type Codec struct {
+	keys []keys
+	// MaxAge rejects values older than it, zero disables the check
+	MaxAge time.Duration
+	now    func() time.Time
+}
+
+// New derives signing and encryption keys from secrets, each should have
+// at least 32 random bytes
+func New(secrets ...[]byte) (*Codec, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("no secrets")
+	}
+
+	c := &Codec{now: time.Now}
+
+	for _, secret := range secrets {
+		if len(secret) < minSecretSize {
+			return nil, errors.New("secret must have at least 32 bytes")
+		}
+
+		signKey, err := hkdf.Key(sha256.New, secret, nil, "cookie signing", keySize)
+		if err != nil {
+			return nil, err
+		}
+		encKey, err := hkdf.Key(sha256.New, secret, nil, "cookie encryption", keySize)
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		c.keys = append(c.keys, keys{sign: signKey, aead: aead})
+	}
+
+	return c, nil
+}
+
+// Sign returns value with timestamp and HMAC-SHA256 of both. The value
+// is readable by client but can't be changed
+func (c *Codec) Sign(name, value string) string {
+	payload := c.withTimestamp(value)
+	mac := c.mac(c.keys[0].sign, name, payload)
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(mac)
+}
+
+// Verify returns the value signed by Sign for cookie with the same name
+func (c *Codec) Verify(name, signed string) (string, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", ErrInvalid
+	}
+
+	payload, err := encoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	mac, err := encoding.DecodeString(encodedMAC)
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	for _, k := range c.keys {
+		if hmac.Equal(mac, c.mac(k.sign, name, payload)) {
+			return c.checkTimestamp(payload)
+		}
+	}
+	return "", ErrInvalid
+}
+
+// Encrypt returns value with timestamp encrypted with AES-256-GCM, cookie
+// name is authenticated as additional data
+func (c *Codec) Encrypt(name, value string) (string, error) {
+	aead := c.keys[0].aead
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, c.withTimestamp(value), []byte(name))
+	return encoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the value encrypted by Encrypt for cookie with the
+// same name
+func (c *Codec) Decrypt(name, encrypted string) (string, error) {
+	sealed, err := encoding.DecodeString(encrypted)
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	for _, k := range c.keys {
+		nonceSize := k.aead.NonceSize()
+		if len(sealed) < nonceSize {
+			return "", ErrInvalid
+		}
+
+		payload, err := k.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+		if err == nil {
+			return c.checkTimestamp(payload)
+		}
+	}
+	return "", ErrInvalid
+}
+
+// mac binds payload to cookie name so values can't be moved between
+// cookies
+func (c *Codec) mac(key []byte, name string, payload []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func (c *Codec) withTimestamp(value string) []byte {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(c.now().Unix()))
+	return append(payload, value...)
+}
+
+func (c *Codec) checkTimestamp(payload []byte) (string, error) {
+	if len(payload) < timestampSize {
+		return "", ErrInvalid
+	}
+
+	created := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if c.MaxAge > 0 && c.now().Sub(created) > c.MaxAge {
+		return "", ErrExpired
+	}
+	return string(payload[timestampSize:]), nil
+}
@@ -0,0 +1,125 @@
+package securecookie
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	secret    = bytes.Repeat([]byte("s"), 32)
+	oldSecret = bytes.Repeat([]byte("o"), 32)
+)
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	assert.Error(t, err)
+	_, err = New([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestSign(t *testing.T) {
+	c, err := New(secret)
+	require.NoError(t, err)
+
+	t.Run("ok, round trip", func(t *testing.T) {
+		signed := c.Sign("user", "42")
+		assert.NotContains(t, signed, ";")
+		v, err := c.Verify("user", signed)
+		require.NoError(t, err)
+		assert.Equal(t, "42", v)
+	})
+	t.Run("ok, rotated secret", func(t *testing.T) {
+		old, err := New(oldSecret)
+		require.NoError(t, err)
+		rotated, err := New(secret, oldSecret)
+		require.NoError(t, err)
+
+		v, err := rotated.Verify("user", old.Sign("user", "42"))
+		require.NoError(t, err)
+		assert.Equal(t, "42", v)
+	})
+	t.Run("fail, tampered", func(t *testing.T) {
+		signed := c.Sign("user", "42")
+		payload, mac, _ := strings.Cut(signed, ".")
+		forged := c.Sign("user", "1")
+		forgedPayload, _, _ := strings.Cut(forged, ".")
+
+		for _, v := range []string{forgedPayload + "." + mac, payload, payload + ".", "!!!." + mac, payload + "." + mac[1:]} {
+			_, err := c.Verify("user", v)
+			assert.ErrorIs(t, err, ErrInvalid, v)
+		}
+	})
+	t.Run("fail, other cookie name", func(t *testing.T) {
+		_, err := c.Verify("admin", c.Sign("user", "42"))
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+	t.Run("fail, other secret", func(t *testing.T) {
+		other, err := New(oldSecret)
+		require.NoError(t, err)
+		_, err = other.Verify("user", c.Sign("user", "42"))
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+}
+
+func TestEncrypt(t *testing.T) {
+	c, err := New(secret)
+	require.NoError(t, err)
+
+	t.Run("ok, round trip", func(t *testing.T) {
+		encrypted, err := c.Encrypt("prefs", "theme=dark")
+		require.NoError(t, err)
+		assert.NotContains(t, encrypted, "dark")
+
+		again, err := c.Encrypt("prefs", "theme=dark")
+		require.NoError(t, err)
+		assert.NotEqual(t, encrypted, again)
+
+		v, err := c.Decrypt("prefs", encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, "theme=dark", v)
+	})
+	t.Run("fail, tampered", func(t *testing.T) {
+		encrypted, err := c.Encrypt("prefs", "theme=dark")
+		require.NoError(t, err)
+
+		tampered := []byte(encrypted)
+		tampered[len(tampered)-2] ^= 1
+		for _, v := range []string{string(tampered), "", "AAAA", "not base64!"} {
+			_, err := c.Decrypt("prefs", v)
+			assert.ErrorIs(t, err, ErrInvalid, v)
+		}
+	})
+	t.Run("fail, other cookie name", func(t *testing.T) {
+		encrypted, err := c.Encrypt("prefs", "theme=dark")
+		require.NoError(t, err)
+		_, err = c.Decrypt("session", encrypted)
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+}
+
+func TestMaxAge(t *testing.T) {
+	c, err := New(secret)
+	require.NoError(t, err)
+	c.MaxAge = time.Hour
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	signed := c.Sign("user", "42")
+	encrypted, err := c.Encrypt("user", "42")
+	require.NoError(t, err)
+
+	c.now = func() time.Time { return now.Add(30 * time.Minute) }
+	_, err = c.Verify("user", signed)
+	assert.NoError(t, err)
+
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, err = c.Verify("user", signed)
+	assert.ErrorIs(t, err, ErrExpired)
+	_, err = c.Decrypt("user", encrypted)
+	assert.ErrorIs(t, err, ErrExpired)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+// HandlerError is the standard way for handlers to finish with a non
+// successful status and message
+type HandlerError struct {
+	StatusCode int
+	Message    string
+}
+
+func NewHandlerError(statusCode int, message string) *HandlerError {
+	return &HandlerError{StatusCode: statusCode, Message: message}
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%d %s", e.StatusCode, e.message())
+}
+
+func (e *HandlerError) message() string {
+	if e.Message == "" {
+		return response.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+// Write sends the error as a complete plain text response
+func (e *HandlerError) Write(w response.Writer) error {
+	body := e.Error()
+
+	if err := w.WriteStatusLine(e.StatusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+		return err
+	}
+	return w.WriteBody([]byte(body))
+}
+
+// ErrorRenderer writes response for an error returned by handler
+type ErrorRenderer func(w response.Writer, req *request.Request, err error)
+
+// DefaultErrorRenderer writes HandlerError as plain text, any other error
+// is logged and answered with 500 without exposing its message
+func DefaultErrorRenderer(w response.Writer, req *request.Request, err error) {
+	TextErrorRenderer(w, req, err)
+}
+
+// handlerErrorFrom finds HandlerError in err chain. Other errors are logged
+// and turned into 500
+func handlerErrorFrom(req *request.Request, err error) *HandlerError {
+	var herr *HandlerError
+
+	if errors.As(err, &herr) {
+		return herr
+	}
+
+	slog.Error(
+		"handler failed",
+		"method", req.RequestLine.Method,
+		"target", req.RequestLine.RequestTarget,
+		"context_error", err,
+	)
+
+	return NewHandlerError(response.StatusInternalServerError, "")
+}
+
+type errorRendererKey struct{}
+
+func withErrorRenderer(ctx context.Context, r ErrorRenderer) context.Context {
+	return context.WithValue(ctx, errorRendererKey{}, r)
+}
+
+// RenderError writes err with ErrorRenderer of the server handling req,
+// so middlewares answer in the same format as handlers. Headers can be
+// added to the response with response.ObservedWriter hooks
+func RenderError(w response.Writer, req *request.Request, err error) {
+	errorRendererFrom(req.Context())(w, req, err)
+}
+
+func errorRendererFrom(ctx context.Context) ErrorRenderer {
+	if r, ok := ctx.Value(errorRendererKey{}).(ErrorRenderer); ok && r != nil {
+		return r
+	}
+	return DefaultErrorRenderer
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+type HandlerFunc func(w response.Writer, req *request.Request)
+
+// ContextHandlerFunc receives context cancelled when client disconnects
+// or server shuts down. Returned error is rendered by the server's
+// ErrorRenderer, use HandlerError to choose status code
+type ContextHandlerFunc func(ctx context.Context, w response.Writer, req *request.Request) error
+
+// HandleContext adapts ContextHandlerFunc to HandlerFunc, so it can be used
+// with middlewares and router
+func HandleContext(h ContextHandlerFunc) HandlerFunc {
+	return func(w response.Writer, req *request.Request) {
+		ctx := req.Context()
+		ow := response.Observe(w)
+
+		err := h(ctx, ow, req)
+		if err == nil {
+			return
+		}
+
+		if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+			slog.Info("request cancelled", "target", req.RequestLine.RequestTarget, "context_error", err)
+			return
+		}
+
+		if ow.WroteStatusLine() {
+			slog.Error(
+				"handler failed after response started",
+				"target", req.RequestLine.RequestTarget,
+				"status", ow.StatusCode,
+				"context_error", err,
+			)
+			return
+		}
+
+		RenderError(ow, req, err)
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleContext(t *testing.T) {
+	cancelled := make(chan error, 1)
+
+	h := HandleContext(func(ctx context.Context, w response.Writer, req *request.Request) error {
+		switch req.RequestLine.RequestTarget {
+		case "/not-found":
+			return NewHandlerError(response.StatusNotFound, "no such user")
+		case "/wrapped":
+			return fmt.Errorf("lookup: %w", NewHandlerError(response.StatusForbidden, ""))
+		case "/internal":
+			return errors.New("database password is hunter2")
+		case "/wait":
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return ctx.Err()
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fallthrough
+		default:
+			body := "ok"
+			if err := w.WriteStatusLine(response.StatusOK); err != nil {
+				return err
+			}
+			if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+				return err
+			}
+			return w.WriteBody([]byte(body))
+		}
+	})
+
+	start := func(t *testing.T, opts ...Option) (*Server, string) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		s := Serve(l, h, opts...)
+		t.Cleanup(func() { s.Close() })
+		return s, l.Addr().String()
+	}
+
+	send := func(t *testing.T, addr, target string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("ok, no error", func(t *testing.T) {
+		_, addr := start(t)
+		assert.Contains(t, send(t, addr, "/"), "HTTP/1.1 200 OK")
+	})
+	t.Run("ok, handler error status", func(t *testing.T) {
+		_, addr := start(t)
+
+		resp := send(t, addr, "/not-found")
+		assert.Contains(t, resp, "HTTP/1.1 404 Not Found")
+		assert.Contains(t, resp, "404 no such user")
+
+		assert.Contains(t, send(t, addr, "/wrapped"), "HTTP/1.1 403 Forbidden")
+	})
+	t.Run("ok, other errors are not exposed", func(t *testing.T) {
+		_, addr := start(t)
+
+		resp := send(t, addr, "/internal")
+		assert.Contains(t, resp, "HTTP/1.1 500 Internal Server Error")
+		assert.NotContains(t, resp, "hunter2")
+	})
+	t.Run("ok, custom error renderer", func(t *testing.T) {
+		renderer := func(w response.Writer, req *request.Request, err error) {
+			NewHandlerError(response.StatusBadRequset, "rendered: "+err.Error()).Write(w)
+		}
+		_, addr := start(t, WithErrorRenderer(renderer))
+
+		assert.Contains(t, send(t, addr, "/internal"), "rendered: database password")
+	})
+	t.Run("ok, context cancelled on connection reset", func(t *testing.T) {
+		_, addr := start(t)
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+		// RST instead of FIN
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+
+		select {
+		case err := <-cancelled:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("context was not cancelled")
+		}
+	})
+	t.Run("ok, half-closed connection is not cancelled", func(t *testing.T) {
+		_, addr := start(t)
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "HTTP/1.1 200 OK")
+	})
+	t.Run("ok, context cancelled when shutdown times out", func(t *testing.T) {
+		s, addr := start(t)
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+		select {
+		case err := <-cancelled:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("context was not cancelled")
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+type IdentityPolicy func(id *request.PeerIdentity) bool
+
+// AllowSPIFFEIDs allows clients with one of the SPIFFE IDs
+func AllowSPIFFEIDs(ids ...string) IdentityPolicy {
+	return func(id *request.PeerIdentity) bool {
+		return id.SPIFFEID != "" && slices.Contains(ids, id.SPIFFEID)
+	}
+}
+
+// AllowDNSNames allows clients having one of the names in DNS SANs
+func AllowDNSNames(names ...string) IdentityPolicy {
+	return func(id *request.PeerIdentity) bool {
+		for _, name := range id.DNSNames {
+			if slices.Contains(names, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RequireIdentity answers 403 unless client presented verified
+// certificate accepted by one of the policies
+func RequireIdentity(next HandlerFunc, policies ...IdentityPolicy) HandlerFunc {
+	return func(w response.Writer, req *request.Request) {
+		id := req.PeerIdentity()
+
+		if id != nil {
+			for _, allowed := range policies {
+				if allowed(id) {
+					next(w, req)
+					return
+				}
+			}
+		}
+
+		slog.Info("client identity is not allowed", "identity", id)
+		RenderError(w, req, NewHandlerError(response.StatusForbidden, ""))
+	}
+}
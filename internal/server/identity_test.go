@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(100),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCA{cert: cert, key: key}
+}
+
+func (ca testCA) clientCert(t *testing.T, cn string, spiffeID string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	u, err := url.Parse(spiffeID)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(101),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"test"}},
+		DNSNames:     []string{cn + ".internal"},
+		URIs:         []*url.URL{u},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func identityHandler(w response.Writer, req *request.Request) {
+	body := "anonymous"
+	if id := req.PeerIdentity(); id != nil {
+		body = id.CommonName + " " + id.SPIFFEID
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func TestClientAuth(t *testing.T) {
+	serverCert := writeSelfSignedCert(t, t.TempDir(), "server", 1, "server.test")
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.cert)
+
+	store, err := NewCertStore(serverCert.files)
+	require.NoError(t, err)
+
+	ca := newTestCA(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	svcA := ca.clientCert(t, "svc-a", "spiffe://example.org/svc/a")
+	svcB := ca.clientCert(t, "svc-b", "spiffe://example.org/svc/b")
+
+	serve := func(t *testing.T, mode ClientAuthMode, h HandlerFunc) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		s := Serve(l, h, WithTLS(store.TLSConfig()), WithClientAuth(mode, clientCAs))
+		t.Cleanup(func() { s.Close() })
+		return l.Addr().String()
+	}
+
+	request := func(addr string, certs ...tls.Certificate) (string, error) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			ServerName:   "server.test",
+			RootCAs:      roots,
+			Certificates: certs,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: server.test\r\n\r\n")); err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(conn)
+		return string(data), err
+	}
+
+	t.Run("ok, required identity exposed on request", func(t *testing.T) {
+		addr := serve(t, ClientAuthRequired, identityHandler)
+
+		body, err := request(addr, svcA)
+		require.NoError(t, err)
+		assert.Contains(t, body, "svc-a spiffe://example.org/svc/a")
+	})
+	t.Run("fail, required without certificate", func(t *testing.T) {
+		addr := serve(t, ClientAuthRequired, identityHandler)
+
+		_, err := request(addr)
+		require.Error(t, err)
+	})
+	t.Run("ok, optional without certificate", func(t *testing.T) {
+		addr := serve(t, ClientAuthOptional, identityHandler)
+
+		body, err := request(addr)
+		require.NoError(t, err)
+		assert.Contains(t, body, "anonymous")
+
+		body, err = request(addr, svcB)
+		require.NoError(t, err)
+		assert.Contains(t, body, "svc-b")
+	})
+	t.Run("fail, certificate from unknown CA", func(t *testing.T) {
+		addr := serve(t, ClientAuthOptional, identityHandler)
+		other := newTestCA(t).clientCert(t, "svc-a", "spiffe://example.org/svc/a")
+
+		_, err := request(addr, other)
+		require.Error(t, err)
+	})
+	t.Run("ok, identity policy", func(t *testing.T) {
+		h := RequireIdentity(identityHandler, AllowSPIFFEIDs("spiffe://example.org/svc/a"))
+		addr := serve(t, ClientAuthOptional, h)
+
+		body, err := request(addr, svcA)
+		require.NoError(t, err)
+		assert.Contains(t, body, "HTTP/1.1 200 OK")
+
+		body, err = request(addr, svcB)
+		require.NoError(t, err)
+		assert.Contains(t, body, "HTTP/1.1 403 Forbidden")
+
+		body, err = request(addr)
+		require.NoError(t, err)
+		assert.Contains(t, body, "HTTP/1.1 403 Forbidden")
+
+		h = RequireIdentity(identityHandler, AllowDNSNames("svc-b.internal"))
+		addr = serve(t, ClientAuthOptional, h)
+
+		body, err = request(addr, svcB)
+		require.NoError(t, err)
+		assert.Contains(t, body, "HTTP/1.1 200 OK")
+	})
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// set by systemd socket activation, see sd_listen_fds(3)
+	envListenFds = "LISTEN_FDS"
+	envListenPid = "LISTEN_PID"
+	// set by Restart for the child process
+	envInheritFds = "HTTP_IMPL_INHERIT_FDS"
+)
+
+// first passed fd, the same for systemd and exec.Cmd.ExtraFiles
+var listenFdsStart = 3
+
+// InheritedListeners returns listeners passed to the process by systemd
+// or by a parent calling Restart. Environment variables are unset, so
+// the fds are taken only once
+func InheritedListeners() ([]net.Listener, error) {
+	n, err := inheritedFdCount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	os.Unsetenv(envListenFds)
+	os.Unsetenv(envListenPid)
+	os.Unsetenv(envInheritFds)
+
+	listeners := make([]net.Listener, 0, n)
+
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("inherited-listener-%d", fd))
+		l, err := net.FileListener(f)
+		f.Close()
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to use inherited fd %d as listener: %v", fd, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+func inheritedFdCount() (int, error) {
+	if v, ok := os.LookupEnv(envInheritFds); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s: %s", envInheritFds, v)
+		}
+		return n, nil
+	}
+
+	pid, ok := os.LookupEnv(envListenPid)
+	if !ok {
+		return 0, nil
+	}
+
+	// fds are meant for another process, e.g. we were started by the activated one
+	if pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	v := os.Getenv(envListenFds)
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", envListenFds, v)
+	}
+
+	return n, nil
+}
+
+// Restart starts a new copy of the running binary which serves on the
+// same listener. Call Shutdown after it to drain the current process
+func (s *Server) Restart() (*os.Process, error) {
+	path, err := os.Executable()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return startProcess(append([]string{path}, os.Args[1:]...), s.listener)
+}
+
+func startProcess(argv []string, listeners ...net.Listener) (*os.Process, error) {
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, l := range listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %T can not be passed to child process", l)
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envListenFds+"=") ||
+			strings.HasPrefix(kv, envListenPid+"=") ||
+			strings.HasPrefix(kv, envInheritFds+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env, fmt.Sprintf("%s=%d", envInheritFds, len(files)))
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return cmd.Process, nil
+}
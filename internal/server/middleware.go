@@ -0,0 +1,41 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain combines middlewares, the first one is the outermost
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Logging logs every request with its response status and size
+func Logging(next HandlerFunc) HandlerFunc {
+	return func(w response.Writer, req *request.Request) {
+		start := time.Now()
+		ow := response.Observe(w)
+
+		next(ow, req)
+
+		slog.Info(
+			"request handled",
+			"method", req.RequestLine.Method,
+			"target", req.RequestLine.RequestTarget,
+			"remote_client_ip", req.RemoteAddr,
+			"status", ow.StatusCode,
+			"bytes", ow.BytesWritten,
+			"duration", time.Since(start),
+		)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(t *testing.T, raw string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func TestChain(t *testing.T) {
+	t.Run("ok, order of middlewares", func(t *testing.T) {
+		calls := []string{}
+		mw := func(name string) Middleware {
+			return func(next HandlerFunc) HandlerFunc {
+				return func(w response.Writer, req *request.Request) {
+					calls = append(calls, name+" before")
+					next(w, req)
+					calls = append(calls, name+" after")
+				}
+			}
+		}
+
+		h := Chain(mw("first"), mw("second"))(func(w response.Writer, req *request.Request) {
+			calls = append(calls, "handler")
+		})
+		h(response.NewWriter(&bytes.Buffer{}), newTestRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		assert.Equal(t, []string{"first before", "second before", "handler", "second after", "first after"}, calls)
+	})
+	t.Run("ok, observe and mutate response", func(t *testing.T) {
+		var observed *response.ObservedWriter
+		addHeader := func(next HandlerFunc) HandlerFunc {
+			return func(w response.Writer, req *request.Request) {
+				observed = response.Observe(w)
+				observed.OnHeaders(func(statusCode int, h headers.Headers) {
+					h.Set("X-Status-Seen", response.StatusText(statusCode))
+				})
+				next(observed, req)
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		h := Chain(Logging, addHeader)(textHandler("hello"))
+		h(response.NewWriter(buf), newTestRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		assert.Equal(t, response.StatusOK, observed.StatusCode)
+		assert.Equal(t, 5, observed.BytesWritten)
+		v, ok := observed.Headers.GetString("X-Status-Seen")
+		assert.True(t, ok)
+		assert.Equal(t, "OK", v)
+		assert.Contains(t, buf.String(), "x-status-seen: OK\r\n")
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/netip"
+)
+
+type Option func(*Server)
+
+// WithTLS makes server accept only TLS connections
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithClientAuth verifies client certificates against the CA pool, it
+// takes effect only together with WithTLS
+func WithClientAuth(mode ClientAuthMode, clientCAs *x509.CertPool) Option {
+	return func(s *Server) {
+		s.clientAuth = mode
+		s.clientCAs = clientCAs
+	}
+}
+
+// WithProxyProtocol expects PROXY protocol v1 or v2 header on connections
+// from trusted networks. Connections with malformed header are closed
+func WithProxyProtocol(trusted ...netip.Prefix) Option {
+	return func(s *Server) {
+		s.proxyTrusted = trusted
+	}
+}
+
+// WithPanicHook sets hook called after a handler panic is recovered
+func WithPanicHook(hook PanicHook) Option {
+	return func(s *Server) {
+		s.panicHook = hook
+	}
+}
+
+// WithErrorRenderer sets how errors returned by ContextHandlerFunc are
+// turned into responses
+func WithErrorRenderer(r ErrorRenderer) Option {
+	return func(s *Server) {
+		s.errorRenderer = r
+	}
+}
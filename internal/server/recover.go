@@ -0,0 +1,68 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"runtime/debug"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+// PanicHook is called with the recovered value and stack of a panicked
+// handler, e.g. to report it to an error tracker
+type PanicHook func(req *request.Request, recovered any, stack []byte)
+
+// recoverPanic answers 500 if handler panicked before starting the
+// response, otherwise the connection is aborted so client does not take
+// partial response as complete. Panic with *HandlerError is answered with
+// its status
+func (s *Server) recoverPanic(conn net.Conn, w *response.ObservedWriter, req *request.Request) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	herr, ok := recovered.(*HandlerError)
+	if !ok {
+		stack := debug.Stack()
+		slog.Error(
+			"handler panicked",
+			"panic", recovered,
+			"method", req.RequestLine.Method,
+			"target", req.RequestLine.RequestTarget,
+			"stack", string(stack),
+		)
+
+		if s.panicHook != nil {
+			s.panicHook(req, recovered, stack)
+		}
+
+		herr = NewHandlerError(response.StatusInternalServerError, "")
+	}
+
+	if w.WroteStatusLine() {
+		slog.Error("response already started, aborting connection", "status", w.StatusCode)
+		abortConn(conn)
+		return
+	}
+
+	if err := herr.Write(w); err != nil {
+		slog.Error("failed to write error response", "context_error", err)
+	}
+}
+
+// abortConn makes close send RST instead of FIN
+func abortConn(conn net.Conn) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			c.SetLinger(0)
+			return
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return
+		}
+	}
+}
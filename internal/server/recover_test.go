@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	hooked := make(chan any, 10)
+	hook := func(req *request.Request, recovered any, stack []byte) {
+		assert.Contains(t, string(stack), "recover_test.go")
+		hooked <- recovered
+	}
+
+	h := func(w response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/before":
+			panic("boom")
+		case "/after":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(100))
+			w.WriteBody([]byte("partial"))
+			panic("boom after")
+		case "/handler-error":
+			panic(NewHandlerError(response.StatusNotFound, "no such thing"))
+		default:
+			textHandler("fine")(w, req)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := Serve(l, h, WithPanicHook(hook))
+	defer s.Close()
+
+	send := func(target string) (string, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		data, err := io.ReadAll(conn)
+		return string(data), err
+	}
+
+	t.Run("ok, 500 before response started", func(t *testing.T) {
+		resp, err := send("/before")
+		require.NoError(t, err)
+		assert.Contains(t, resp, "HTTP/1.1 500 Internal Server Error")
+		assert.Equal(t, "boom", <-hooked)
+	})
+	t.Run("ok, connection aborted after response started", func(t *testing.T) {
+		resp, err := send("/after")
+		if err == nil {
+			assert.NotContains(t, resp, "500")
+		} else {
+			assert.ErrorContains(t, err, "reset")
+		}
+		assert.Equal(t, "boom after", <-hooked)
+	})
+	t.Run("ok, handler error status", func(t *testing.T) {
+		resp, err := send("/handler-error")
+		require.NoError(t, err)
+		assert.Contains(t, resp, "HTTP/1.1 404 Not Found")
+		assert.Contains(t, resp, "404 no such thing")
+		assert.Empty(t, hooked)
+	})
+	t.Run("ok, server keeps serving", func(t *testing.T) {
+		resp, err := send("/")
+		require.NoError(t, err)
+		assert.Contains(t, resp, "fine")
+	})
+}
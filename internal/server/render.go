@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+)
+
+const (
+	MediaTypeText    = "text/plain"
+	MediaTypeHTML    = "text/html"
+	MediaTypeJSON    = "application/json"
+	MediaTypeProblem = "application/problem+json"
+)
+
+// ErrorPage is passed to HTML error templates
+type ErrorPage struct {
+	StatusCode int
+	StatusText string
+	Message    string
+}
+
+func writeErrorResponse(w response.Writer, statusCode int, contentType string, body []byte) {
+	h := response.GetDefaultHeaders(len(body))
+	h.Change("Content-Type", contentType)
+
+	err := w.WriteStatusLine(statusCode)
+	if err == nil {
+		err = w.WriteHeaders(h)
+	}
+	if err == nil {
+		err = w.WriteBody(body)
+	}
+
+	if err != nil {
+		slog.Error("failed to write error response", "context_error", err)
+	}
+}
+
+func TextErrorRenderer(w response.Writer, req *request.Request, err error) {
+	herr := handlerErrorFrom(req, err)
+	writeErrorResponse(w, herr.StatusCode, MediaTypeText, []byte(herr.Error()))
+}
+
+// HTMLErrorRenderer executes tmpl with ErrorPage
+func HTMLErrorRenderer(tmpl *template.Template) ErrorRenderer {
+	return func(w response.Writer, req *request.Request, err error) {
+		herr := handlerErrorFrom(req, err)
+		page := ErrorPage{
+			StatusCode: herr.StatusCode,
+			StatusText: response.StatusText(herr.StatusCode),
+			Message:    herr.message(),
+		}
+
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, page); err != nil {
+			slog.Error("failed to execute error template", "context_error", err)
+			TextErrorRenderer(w, req, herr)
+			return
+		}
+
+		writeErrorResponse(w, herr.StatusCode, MediaTypeHTML, buf.Bytes())
+	}
+}
+
+// problem is RFC 9457 problem details object
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func ProblemErrorRenderer(w response.Writer, req *request.Request, err error) {
+	herr := handlerErrorFrom(req, err)
+	p := problem{
+		Type:     "about:blank",
+		Title:    response.StatusText(herr.StatusCode),
+		Status:   herr.StatusCode,
+		Detail:   herr.Message,
+		Instance: req.RequestLine.RequestTarget,
+	}
+
+	body, jsonErr := json.Marshal(p)
+	if jsonErr != nil {
+		TextErrorRenderer(w, req, herr)
+		return
+	}
+
+	writeErrorResponse(w, herr.StatusCode, MediaTypeProblem, body)
+}
+
+type ErrorFormat struct {
+	MediaType string
+	Render    ErrorRenderer
+}
+
+// NegotiatedErrorRenderer picks format by request Accept header, the first
+// format is used when none is acceptable. It panics without formats
+func NegotiatedErrorRenderer(formats ...ErrorFormat) ErrorRenderer {
+	if len(formats) == 0 {
+		panic("negotiated error renderer needs at least one format")
+	}
+
+	offers := make([]string, len(formats))
+	for i, f := range formats {
+		offers[i] = f.MediaType
+	}
+
+	return func(w response.Writer, req *request.Request, err error) {
+		// error is sent anyway, so an unacceptable Accept gets the first format
+		chosen, _ := req.Headers.Negotiate(offers...)
+
+		for _, f := range formats {
+			if f.MediaType == chosen {
+				f.Render(w, req, err)
+				return
+			}
+		}
+
+		formats[0].Render(w, req, err)
+	}
+}
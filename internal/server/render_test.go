@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testErrorPage = template.Must(template.New("error").Parse(
+	"<h1>{{.StatusCode}} {{.StatusText}}</h1><p>{{.Message}}</p>",
+))
+
+func TestErrorRenderers(t *testing.T) {
+	render := func(r ErrorRenderer, raw string, err error) string {
+		buf := &bytes.Buffer{}
+		r(response.NewWriter(buf), newTestRequest(t, raw), err)
+		return buf.String()
+	}
+	raw := "GET /users/1 HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	t.Run("ok, text", func(t *testing.T) {
+		resp := render(TextErrorRenderer, raw, NewHandlerError(response.StatusNotFound, "no user"))
+		assert.Contains(t, resp, "HTTP/1.1 404 Not Found\r\n")
+		assert.Contains(t, resp, "content-type: text/plain\r\n")
+		assert.Contains(t, resp, "\r\n\r\n404 no user")
+	})
+	t.Run("ok, html template", func(t *testing.T) {
+		resp := render(HTMLErrorRenderer(testErrorPage), raw, NewHandlerError(response.StatusNotFound, "<script>"))
+		assert.Contains(t, resp, "content-type: text/html\r\n")
+		assert.Contains(t, resp, "<h1>404 Not Found</h1><p>&lt;script&gt;</p>")
+	})
+	t.Run("ok, problem json", func(t *testing.T) {
+		resp := render(ProblemErrorRenderer, raw, NewHandlerError(response.StatusForbidden, "not your user"))
+		assert.Contains(t, resp, "HTTP/1.1 403 Forbidden\r\n")
+		assert.Contains(t, resp, "content-type: application/problem+json\r\n")
+		assert.Contains(t, resp, `{"type":"about:blank","title":"Forbidden","status":403,"detail":"not your user","instance":"/users/1"}`)
+	})
+	t.Run("ok, internal errors are hidden", func(t *testing.T) {
+		resp := render(ProblemErrorRenderer, raw, errors.New("secret"))
+		assert.Contains(t, resp, `"status":500`)
+		assert.NotContains(t, resp, "secret")
+	})
+	t.Run("ok, negotiated by accept", func(t *testing.T) {
+		r := NegotiatedErrorRenderer(
+			ErrorFormat{MediaTypeHTML, HTMLErrorRenderer(testErrorPage)},
+			ErrorFormat{MediaTypeProblem, ProblemErrorRenderer},
+			ErrorFormat{MediaTypeText, TextErrorRenderer},
+		)
+		herr := NewHandlerError(response.StatusNotFound, "")
+
+		cases := map[string]string{
+			"":                                  MediaTypeHTML,
+			"application/json":                  MediaTypeHTML,
+			"application/problem+json":          MediaTypeProblem,
+			"text/*":                            MediaTypeHTML,
+			"text/*;q=0.5, text/plain":          MediaTypeText,
+			"text/html;q=0.1, */*;q=0.5":        MediaTypeProblem,
+			"application/*, text/html;q=0.9":    MediaTypeProblem,
+			"text/plain;q=0.2, text/html;q=0.3": MediaTypeHTML,
+		}
+		for accept, want := range cases {
+			req := "GET / HTTP/1.1\r\nAccept: " + accept + "\r\n\r\n"
+			if accept == "" {
+				req = "GET / HTTP/1.1\r\n\r\n"
+			}
+			assert.Contains(t, render(r, req, herr), "content-type: "+want+"\r\n", accept)
+		}
+	})
+	t.Run("ok, render error with renderer of the server", func(t *testing.T) {
+		req := newTestRequest(t, raw)
+		buf := &bytes.Buffer{}
+		RenderError(response.NewWriter(buf), req, NewHandlerError(response.StatusForbidden, ""))
+		assert.Contains(t, buf.String(), "content-type: text/plain\r\n")
+
+		req = req.WithContext(withErrorRenderer(req.Context(), ProblemErrorRenderer))
+		buf.Reset()
+		RenderError(response.NewWriter(buf), req, NewHandlerError(response.StatusForbidden, ""))
+		assert.Contains(t, buf.String(), "content-type: application/problem+json\r\n")
+		assert.Contains(t, buf.String(), `"status":403`)
+	})
+	t.Run("fail, negotiated without formats", func(t *testing.T) {
+		assert.Panics(t, func() { NegotiatedErrorRenderer() })
+	})
+}
+
+func TestParserErrorResponse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	renderer := NegotiatedErrorRenderer(
+		ErrorFormat{MediaTypeText, TextErrorRenderer},
+		ErrorFormat{MediaTypeProblem, ProblemErrorRenderer},
+	)
+	s := Serve(l, textHandler("never"), WithErrorRenderer(renderer))
+	defer s.Close()
+
+	send := func(data string) string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte(data))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(resp)
+	}
+
+	t.Run("ok, plain text by default", func(t *testing.T) {
+		resp := send("GET / HTTP/1.1\r\nH@st: localhost\r\n\r\n")
+		assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+		assert.Contains(t, resp, "content-type: text/plain\r\n")
+		assert.Contains(t, resp, "connection: close\r\n")
+	})
+	t.Run("ok, problem json by accept", func(t *testing.T) {
+		resp := send("GET / HTTP/1.1\r\nAccept: application/problem+json\r\nH@st: localhost\r\n\r\n")
+		assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+		assert.Contains(t, resp, `"status":400`)
+	})
+	t.Run("ok, invalid request line", func(t *testing.T) {
+		resp := send("get / HTTP/1.1\r\n\r\n")
+		assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+	})
+}
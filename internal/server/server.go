@@ -1,49 +1,93 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/netip"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/SSL0/http-impl/internal/proxyproto"
 	"github.com/SSL0/http-impl/internal/request"
 	"github.com/SSL0/http-impl/internal/response"
 )
 
-type HandlerError struct {
-	StatusCode int
-	Message    string
+const proxyHeaderTimeout = 5 * time.Second
+
+// accept errors like EMFILE are retried after a delay doubling up to the
+// maximum, so the loop doesn't spin while they last
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+)
+
+type Server struct {
+	listener   net.Listener
+	handler    HandlerFunc
+	closed     atomic.Bool
+	conns      sync.WaitGroup
+	tlsConfig  *tls.Config
+	clientAuth ClientAuthMode
+	clientCAs  *x509.CertPool
+
+	proxyTrusted  []netip.Prefix
+	panicHook     PanicHook
+	errorRenderer ErrorRenderer
+
+	// ctx is the parent of all request contexts, cancelled when server is
+	// closed or graceful shutdown times out
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func (e *HandlerError) Write(w io.Writer) error {
-	data := fmt.Sprintf("%d %s", e.StatusCode, e.Message)
-	n, err := w.Write([]byte(data))
+func newServer(l net.Listener, f HandlerFunc, opts ...Option) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		listener:      l,
+		handler:       f,
+		closed:        atomic.Bool{},
+		errorRenderer: DefaultErrorRenderer,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
 
-	if n != len(data) {
-		return fmt.Errorf("failed to write full handler error data: %s", data)
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	return err
+	if s.tlsConfig != nil && s.clientAuth != ClientAuthNone {
+		s.tlsConfig = s.tlsConfig.Clone()
+		s.tlsConfig.ClientAuth = s.clientAuth.tlsClientAuth()
+		s.tlsConfig.ClientCAs = s.clientCAs
+	}
+
+	return s
 }
 
-type HandlerFunc func(w *response.Writer, req *request.Request)
+// ListenAndServe serves on a listener inherited from systemd or a parent
+// process if there is one, otherwise it listens on the given port
+func ListenAndServe(port uint16, f HandlerFunc, opts ...Option) (*Server, error) {
+	inherited, err := InheritedListeners()
 
-type Server struct {
-	listener *net.Listener
-	handler  HandlerFunc
-	closed   atomic.Bool
-}
+	if err != nil {
+		return nil, err
+	}
 
-func newServer(l *net.Listener, f HandlerFunc) *Server {
-	return &Server{
-		listener: l,
-		handler:  f,
-		closed:   atomic.Bool{},
+	if len(inherited) > 0 {
+		for _, l := range inherited[1:] {
+			l.Close()
+		}
+		slog.Info("using inherited listener", "address", inherited[0].Addr().String())
+		return Serve(inherited[0], f, opts...), nil
 	}
-}
 
-func ListenAndServe(port uint16, f HandlerFunc) (*Server, error) {
 	address := fmt.Sprintf(":%d", port)
 	l, err := net.Listen("tcp", address)
 
@@ -51,50 +95,165 @@ func ListenAndServe(port uint16, f HandlerFunc) (*Server, error) {
 		return nil, err
 	}
 
-	server := newServer(&l, f)
+	return Serve(l, f, opts...), nil
+}
+
+func Serve(l net.Listener, f HandlerFunc, opts ...Option) *Server {
+	server := newServer(l, f, opts...)
 
 	go server.Serve()
 
-	return server, nil
+	return server
 }
 
 func (s *Server) Serve() {
-	s.closed.Store(false)
+	var delay time.Duration
 	for !s.closed.Load() {
-		conn, err := (*s.listener).Accept()
+		conn, err := s.listener.Accept()
 
 		if err != nil {
-			slog.Error("failed to listen", "context_error", err)
+			if s.closed.Load() {
+				return
+			}
+			delay = acceptDelay(delay)
+			slog.Error("failed to listen", "retry_in", delay, "context_error", err)
+			time.Sleep(delay)
+			continue
 		}
+		delay = 0
 
-		slog.Info("conn successfully accepted", "remote_client_ip", conn.RemoteAddr().String())
-
-		go s.handle(conn)
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.handle(conn)
+		}()
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
 
+	if len(s.proxyTrusted) > 0 {
+		proxyConn, err := proxyproto.Wrap(conn, s.proxyTrusted, proxyHeaderTimeout)
+		if err != nil {
+			slog.Error("failed to read proxy protocol header", "proxy_ip", conn.RemoteAddr().String(), "context_error", err)
+			return
+		}
+		conn = proxyConn
+	}
+
+	if pc, ok := conn.(*proxyproto.Conn); ok {
+		slog.Info(
+			"conn successfully accepted",
+			"remote_client_ip", pc.RemoteAddr().String(),
+			"local_ip", pc.LocalAddr().String(),
+			"proxy_ip", pc.ProxyAddr().String(),
+		)
+	} else {
+		slog.Info("conn successfully accepted", "remote_client_ip", conn.RemoteAddr().String())
+	}
+
+	var tlsConn *tls.Conn
+	if s.tlsConfig != nil {
+		tlsConn = tls.Server(conn, s.tlsConfig)
+		conn = tlsConn
+
+		if err := tlsConn.Handshake(); err != nil {
+			slog.Error("failed tls handshake", "context_error", err)
+			return
+		}
+	}
+
 	req, err := request.RequestFromReader(conn)
 
 	if err != nil {
 		slog.Error("failed to get request from client", "context_error", err)
 
-		errMsg := fmt.Sprintf(
-			"%d %s",
-			response.StatusBadRequset,
-			response.StatusText(response.StatusBadRequset),
-		)
-
-		conn.Write([]byte(errMsg))
+		herr := NewHandlerError(response.StatusBadRequset, "")
+		s.errorRenderer(response.NewWriter(conn), req, herr)
 		return
 	}
 
-	rWriter := response.NewWriter(conn)
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.LocalAddr = conn.LocalAddr().String()
+
+	if tlsConn != nil {
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+	}
+
+	ctx, cancel := context.WithCancel(withErrorRenderer(s.ctx, s.errorRenderer))
+	defer cancel()
+	go cancelOnDisconnect(conn, cancel)
+	req = req.WithContext(ctx)
+
+	rWriter := response.Observe(response.NewWriter(conn))
+	defer s.recoverPanic(conn, rWriter, req)
+
 	s.handler(rWriter, req)
 }
 
-func (s *Server) Close() {
-	s.closed.Store(true)
+func acceptDelay(prev time.Duration) time.Duration {
+	if prev == 0 {
+		return acceptMinDelay
+	}
+	return min(2*prev, acceptMaxDelay)
+}
+
+func (s *Server) Listener() net.Listener {
+	return s.listener
+}
+
+// cancelOnDisconnect waits until connection fails, e.g. client resets it.
+// A request is read completely at this point, so nothing else is expected
+// from client. EOF doesn't cancel: client may only shut down its writing
+// side and still wait for response, which can't be told apart from closing
+// the connection until the response is written
+func cancelOnDisconnect(conn net.Conn, cancel context.CancelFunc) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if !errors.Is(err, io.EOF) {
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+func (s *Server) closeListener() error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Close stops accepting connections and cancels contexts of requests
+// which are being handled
+func (s *Server) Close() error {
+	s.cancel()
+	return s.closeListener()
+}
+
+// Shutdown stops accepting new connections and waits until the ones
+// already accepted are handled or ctx is done. In the latter case
+// contexts of requests still being handled are cancelled
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.closeListener(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
 }
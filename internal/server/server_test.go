@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const helperEnv = "HTTP_IMPL_TEST_HELPER"
+
+func textHandler(body string) HandlerFunc {
+	return func(w response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func doRequest(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestInheritedListeners(t *testing.T) {
+	t.Run("ok, systemd socket activation", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		f, err := l.(*net.TCPListener).File()
+		require.NoError(t, err)
+		defer f.Close()
+
+		prev := listenFdsStart
+		listenFdsStart = int(f.Fd())
+		defer func() { listenFdsStart = prev }()
+
+		t.Setenv(envListenFds, "1")
+		t.Setenv(envListenPid, strconv.Itoa(os.Getpid()))
+
+		listeners, err := InheritedListeners()
+		require.NoError(t, err)
+		require.Len(t, listeners, 1)
+		assert.Equal(t, l.Addr().String(), listeners[0].Addr().String())
+
+		_, ok := os.LookupEnv(envListenFds)
+		assert.False(t, ok)
+
+		s := Serve(listeners[0], textHandler("inherited"))
+		defer s.Close()
+
+		assert.Contains(t, doRequest(t, l.Addr().String()), "inherited")
+	})
+	t.Run("ok, fds for another pid are ignored", func(t *testing.T) {
+		t.Setenv(envListenFds, "1")
+		t.Setenv(envListenPid, strconv.Itoa(os.Getpid()+1))
+
+		listeners, err := InheritedListeners()
+		require.NoError(t, err)
+		assert.Empty(t, listeners)
+	})
+	t.Run("fail, invalid fds count", func(t *testing.T) {
+		t.Setenv(envInheritFds, "many")
+
+		_, err := InheritedListeners()
+		require.Error(t, err)
+	})
+}
+
+// failingListener fails first accepts like a process out of descriptors
+type failingListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, syscall.EMFILE
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	t.Run("ok, accept errors are retried with growing delay", func(t *testing.T) {
+		assert.Equal(t, acceptMinDelay, acceptDelay(0))
+		assert.Equal(t, 2*acceptMinDelay, acceptDelay(acceptMinDelay))
+		assert.Equal(t, acceptMaxDelay, acceptDelay(acceptMaxDelay))
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		failing := &failingListener{Listener: l}
+		failing.failures.Store(4)
+
+		start := time.Now()
+		s := Serve(failing, textHandler("accepted"))
+		defer s.Close()
+
+		assert.Contains(t, doRequest(t, l.Addr().String()), "accepted")
+		// 5 + 10 + 20 + 40ms
+		assert.GreaterOrEqual(t, time.Since(start), 75*time.Millisecond)
+	})
+}
+
+func TestRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+
+	s := Serve(l, textHandler("parent"))
+	assert.Contains(t, doRequest(t, addr), "parent")
+
+	t.Setenv(helperEnv, "1")
+	proc, err := startProcess([]string{os.Args[0], "-test.run=^TestHelperServer$"}, l)
+	require.NoError(t, err)
+	defer func() {
+		proc.Kill()
+		proc.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	// the socket is still open in the child, so connection is not refused
+	assert.Contains(t, doRequest(t, addr), "child")
+}
+
+// TestHelperServer is run as child process by TestRestart
+func TestHelperServer(t *testing.T) {
+	if os.Getenv(helperEnv) != "1" {
+		t.Skip("helper process")
+	}
+
+	listeners, err := InheritedListeners()
+	if err != nil || len(listeners) != 1 {
+		os.Exit(1)
+	}
+
+	Serve(listeners[0], textHandler("child"))
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	h := func(w response.Writer, req *request.Request) {
+		body := req.RemoteAddr + " " + req.LocalAddr
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+	s := Serve(l, h, WithProxyProtocol(netip.MustParsePrefix("127.0.0.0/8")))
+	defer s.Close()
+
+	send := func(data string) string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte(data))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(resp)
+	}
+
+	t.Run("ok, original addresses on request", func(t *testing.T) {
+		resp := send("PROXY TCP4 203.0.113.7 198.51.100.1 4000 443\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.Contains(t, resp, "203.0.113.7:4000 198.51.100.1:443")
+	})
+	t.Run("fail, malformed header closes connection", func(t *testing.T) {
+		resp := send("PROXY TCP4 nonsense\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.Empty(t, resp)
+	})
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CertFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertStore keeps certificates loaded from files and selects one by SNI
+// hostname. Certificates can be reloaded while server is running, already
+// established connections are not affected
+type CertStore struct {
+	files []CertFiles
+
+	mu       sync.RWMutex
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+	modTimes map[string]time.Time
+}
+
+func NewCertStore(files ...CertFiles) (*CertStore, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no certificates provided")
+	}
+
+	s := &CertStore{files: files}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload loads all certificates again. On error the previously loaded
+// certificates are kept
+func (s *CertStore) Reload() error {
+	byName := map[string]*tls.Certificate{}
+	modTimes := map[string]time.Time{}
+	var fallback *tls.Certificate
+
+	for _, f := range s.files {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %v", f.CertFile, err)
+		}
+
+		for _, name := range certNames(cert.Leaf) {
+			if _, ok := byName[name]; !ok {
+				byName[name] = &cert
+			}
+		}
+
+		if fallback == nil {
+			fallback = &cert
+		}
+
+		for _, path := range []string{f.CertFile, f.KeyFile} {
+			if info, err := os.Stat(path); err == nil {
+				modTimes[path] = info.ModTime()
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.byName = byName
+	s.fallback = fallback
+	s.modTimes = modTimes
+	s.mu.Unlock()
+
+	slog.Info("certificates loaded", "count", len(s.files))
+
+	return nil
+}
+
+func certNames(leaf *x509.Certificate) []string {
+	if leaf == nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, name := range leaf.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+
+	return names
+}
+
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	return s.fallback, nil
+}
+
+func (s *CertStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, f := range s.files {
+		for _, path := range []string{f.CertFile, f.KeyFile} {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if !info.ModTime().Equal(s.modTimes[path]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Watch reloads certificates when one of the files is modified, it
+// blocks until ctx is done
+func (s *CertStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				slog.Error("failed to reload certificates", "context_error", err)
+			}
+		}
+	}
+}
+
+func (s *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.GetCertificate,
+	}
+}
+
+type ClientAuthMode int
+
+const (
+	ClientAuthNone ClientAuthMode = iota
+	// certificate is verified only if client sent it
+	ClientAuthOptional
+	ClientAuthRequired
+)
+
+func ParseClientAuthMode(s string) (ClientAuthMode, error) {
+	switch s {
+	case "none", "":
+		return ClientAuthNone, nil
+	case "optional":
+		return ClientAuthOptional, nil
+	case "required":
+		return ClientAuthRequired, nil
+	default:
+		return ClientAuthNone, fmt.Errorf("unknown client auth mode: %s", s)
+	}
+}
+
+func (m ClientAuthMode) tlsClientAuth() tls.ClientAuthType {
+	switch m {
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven
+	case ClientAuthRequired:
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// LoadCertPool reads PEM encoded CA certificates from files
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", file)
+		}
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	files CertFiles
+	cert  *x509.Certificate
+}
+
+// writeSelfSignedCert generates certificate for names and writes it with
+// its key into dir
+func writeSelfSignedCert(t *testing.T, dir, prefix string, serial int64, names ...string) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	files := CertFiles{
+		CertFile: filepath.Join(dir, prefix+".crt"),
+		KeyFile:  filepath.Join(dir, prefix+".key"),
+	}
+	err = os.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	require.NoError(t, err)
+	err = os.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600)
+	require.NoError(t, err)
+
+	return testCert{files: files, cert: cert}
+}
+
+func sniHandler(w response.Writer, req *request.Request) {
+	body := "plain"
+	if req.TLS != nil {
+		body = req.TLS.ServerName
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func doTLSRequest(t *testing.T, addr, serverName string, roots *x509.CertPool) (string, *x509.Certificate) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, RootCAs: roots})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + serverName + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(data), conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	a := writeSelfSignedCert(t, dir, "a", 1, "a.test")
+	b := writeSelfSignedCert(t, dir, "b", 2, "*.b.test")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(a.cert)
+	roots.AddCert(b.cert)
+
+	store, err := NewCertStore(a.files, b.files)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := Serve(l, sniHandler, WithTLS(store.TLSConfig()))
+	defer s.Close()
+	addr := l.Addr().String()
+
+	t.Run("ok, certificate selected by SNI", func(t *testing.T) {
+		body, cert := doTLSRequest(t, addr, "a.test", roots)
+		assert.Equal(t, big.NewInt(1), cert.SerialNumber)
+		assert.Contains(t, body, "a.test")
+
+		body, cert = doTLSRequest(t, addr, "www.b.test", roots)
+		assert.Equal(t, big.NewInt(2), cert.SerialNumber)
+		assert.Contains(t, body, "www.b.test")
+	})
+	t.Run("ok, reload certificate", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "a.test", RootCAs: roots})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		renewed := writeSelfSignedCert(t, dir, "a", 3, "a.test")
+		require.NoError(t, store.Reload())
+		roots.AddCert(renewed.cert)
+
+		_, cert := doTLSRequest(t, addr, "a.test", roots)
+		assert.Equal(t, big.NewInt(3), cert.SerialNumber)
+
+		// connection established before reload is still served
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: a.test\r\n\r\n"))
+		require.NoError(t, err)
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "200 OK")
+	})
+	t.Run("ok, changed files detected", func(t *testing.T) {
+		assert.False(t, store.changed())
+
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(b.files.CertFile, later, later))
+		assert.True(t, store.changed())
+
+		require.NoError(t, store.Reload())
+		assert.False(t, store.changed())
+	})
+	t.Run("fail, broken certificate keeps previous", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(b.files.KeyFile, []byte("broken"), 0o600))
+		require.Error(t, store.Reload())
+
+		_, cert := doTLSRequest(t, addr, "www.b.test", roots)
+		assert.Equal(t, big.NewInt(2), cert.SerialNumber)
+	})
+}
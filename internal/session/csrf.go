@@ -0,0 +1,75 @@
+package session
+
+import (
+	"crypto/subtle"
+	"net/url"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const (
+	csrfKey = "_csrf"
+	// CSRFHeader carries token in requests made by scripts
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField carries token in url-encoded form posts
+	CSRFField = "csrf_token"
+)
+
+// CSRFToken returns token to embed into forms of the session, it is
+// created on first use
+func (s *Session) CSRFToken() string {
+	token, ok := s.values[csrfKey]
+	if !ok {
+		token = newID()
+		s.Set(csrfKey, token)
+	}
+	return token
+}
+
+// VerifyCSRF rejects requests with unsafe methods without the session
+// CSRF token in CSRFHeader or CSRFField with 403. It has to run after
+// Middleware
+func VerifyCSRF(next server.HandlerFunc) server.HandlerFunc {
+	return func(w response.Writer, req *request.Request) {
+		switch req.RequestLine.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+			next(w, req)
+			return
+		}
+
+		s := FromRequest(req)
+		if s == nil || !validCSRFToken(s, requestCSRFToken(req)) {
+			server.RenderError(w, req, server.NewHandlerError(response.StatusForbidden, "invalid CSRF token"))
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+func requestCSRFToken(req *request.Request) string {
+	if token, ok := req.Headers.GetString(CSRFHeader); ok {
+		return token
+	}
+
+	contentType, ok := req.Headers.GetElement("Content-Type")
+	if !ok || contentType.Token != "application/x-www-form-urlencoded" {
+		return ""
+	}
+
+	form, err := url.ParseQuery(string(req.Body))
+	if err != nil {
+		return ""
+	}
+	return form.Get(CSRFField)
+}
+
+func validCSRFToken(s *Session, token string) bool {
+	expected, ok := s.values[csrfKey]
+	if !ok || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
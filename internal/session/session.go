@@ -0,0 +1,255 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/securecookie"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const (
+	defaultCookieName = "session"
+	defaultTTL        = 24 * time.Hour
+	idSize            = 32
+)
+
+type Options struct {
+	Store Store
+	// CookieName is "session" by default
+	CookieName string
+	// TTL is session lifetime since the last change, 24 hours by default
+	TTL time.Duration
+	// Codec signs session ID in cookie if set, so forged IDs are rejected
+	// without accessing the store
+	Codec *securecookie.Codec
+
+	// Path is "/" by default
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite response.SameSite
+}
+
+// Session holds values of one client. It is changed only by the handler
+// of the request, so it is not safe for concurrent use
+type Session struct {
+	id     string
+	values map[string]string
+	// oldID is deleted from the store when the session is saved
+	oldID     string
+	isNew     bool
+	changed   bool
+	destroyed bool
+	// headersSent is set when cookie can't be changed anymore
+	headersSent bool
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew reports whether client had no valid session
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Set after Destroy starts a new session with the ID given by Destroy
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.changed = true
+	s.destroyed = false
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// ErrHeadersSent is returned by RenewID after response headers are
+// written, client wouldn't get cookie with the new ID
+var ErrHeadersSent = errors.New("session cookie is already sent")
+
+// RenewID moves values to a new ID. It has to be called on privilege
+// change, e.g. login, so an ID known to attacker before becomes useless.
+// It has to be called before response headers are written
+func (s *Session) RenewID() error {
+	if s.headersSent {
+		return ErrHeadersSent
+	}
+
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.changed = true
+	return nil
+}
+
+// Destroy removes session from the store and the cookie from client. The
+// ID is replaced, so values set later are saved as a new session
+func (s *Session) Destroy() {
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.values = map[string]string{}
+	s.isNew = true
+	s.destroyed = true
+}
+
+type sessionKey struct{}
+
+// FromRequest returns session loaded by Middleware, nil if there is none
+func FromRequest(req *request.Request) *Session {
+	s, _ := req.Context().Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Middleware loads session of the request and saves it when handler
+// writes response headers, so the cookie can be sent. Changes made after
+// that are saved to the store when handler returns
+func Middleware(opts Options) server.Middleware {
+	if opts.CookieName == "" {
+		opts.CookieName = defaultCookieName
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultTTL
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			ctx := req.Context()
+			s := opts.load(ctx, req)
+			req = req.WithContext(context.WithValue(ctx, sessionKey{}, s))
+
+			ow := response.Observe(w)
+			ow.OnHeaders(func(_ int, h headers.Headers) {
+				opts.save(ctx, s, h)
+				s.headersSent = true
+			})
+
+			next(ow, req)
+			opts.save(ctx, s, nil)
+		}
+	}
+}
+
+func (o Options) load(ctx context.Context, req *request.Request) *Session {
+	fresh := &Session{id: newID(), values: map[string]string{}, isNew: true}
+
+	cookie, ok := req.Cookie(o.CookieName)
+	if !ok {
+		return fresh
+	}
+
+	id := cookie.Value
+	if o.Codec != nil {
+		var err error
+		if id, err = o.Codec.Verify(o.CookieName, id); err != nil {
+			return fresh
+		}
+	}
+
+	values, err := o.Store.Load(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			slog.Error("failed to load session", "context_error", err)
+		}
+		// unknown ID is never reused, otherwise attacker could fixate it
+		return fresh
+	}
+
+	if values == nil {
+		values = map[string]string{}
+	}
+	return &Session{id: id, values: values}
+}
+
+// save writes session to the store and Set-Cookie to h if it's not nil
+func (o Options) save(ctx context.Context, s *Session, h headers.Headers) {
+	if s.oldID != "" {
+		if err := o.Store.Delete(ctx, s.oldID); err != nil {
+			slog.Error("failed to delete renewed session", "context_error", err)
+		}
+		s.oldID = ""
+	}
+
+	// stored ID is already deleted as oldID
+	if s.destroyed {
+		if h != nil {
+			response.DeleteCookie(h, o.CookieName, o.Path, o.Domain)
+		}
+		s.destroyed, s.changed = false, false
+		return
+	}
+
+	// empty new sessions aren't stored until something is set
+	if !s.changed {
+		return
+	}
+
+	if err := o.Store.Save(ctx, s.id, maps.Clone(s.values), o.TTL); err != nil {
+		slog.Error("failed to save session", "context_error", err)
+		return
+	}
+	s.changed = false
+	s.isNew = false
+
+	if h == nil {
+		return
+	}
+
+	value := s.id
+	if o.Codec != nil {
+		value = o.Codec.Sign(o.CookieName, s.id)
+	}
+
+	err := response.SetCookie(h, response.Cookie{
+		Name:     o.CookieName,
+		Value:    value,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   int(o.TTL / time.Second),
+		Secure:   o.Secure,
+		HttpOnly: true,
+		SameSite: o.SameSite,
+	})
+	if err != nil {
+		slog.Error("failed to set session cookie", "context_error", err)
+	}
+	// response with session cookie must not be shared by caches
+	h.Set("Cache-Control", "no-store")
+}
+
+func newID() string {
+	b := make([]byte, idSize)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validID(id string) bool {
+	if len(id) != hex.EncodedLen(idSize) {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,236 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/securecookie"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler(w response.Writer, req *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// serve runs h and returns raw response and value of session cookie set
+// by it
+func serve(t *testing.T, h server.HandlerFunc, method, cookie string, extra ...string) (string, string) {
+	t.Helper()
+	return serveBody(t, h, method, cookie, "", extra...)
+}
+
+func serveBody(t *testing.T, h server.HandlerFunc, method, cookie, body string, extra ...string) (string, string) {
+	t.Helper()
+	raw := method + " / HTTP/1.1\r\nHost: localhost\r\n"
+	if cookie != "" {
+		raw += "Cookie: session=" + cookie + "\r\n"
+	}
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	if body != "" {
+		raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n" + body))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+
+	resp := buf.String()
+	for _, line := range strings.Split(resp, "\r\n") {
+		if v, ok := strings.CutPrefix(line, "set-cookie: session="); ok {
+			value, _, _ := strings.Cut(v, ";")
+			return resp, value
+		}
+	}
+	return resp, ""
+}
+
+func TestMiddleware(t *testing.T) {
+	store := NewMemoryStore()
+	mw := Middleware(Options{Store: store, Secure: true})
+
+	var seen *Session
+	login := mw(func(w response.Writer, req *request.Request) {
+		seen = FromRequest(req)
+		require.NoError(t, seen.RenewID())
+		seen.Set("user", "admin")
+		okHandler(w, req)
+	})
+	whoami := mw(func(w response.Writer, req *request.Request) {
+		seen = FromRequest(req)
+		okHandler(w, req)
+	})
+	logout := mw(func(w response.Writer, req *request.Request) {
+		FromRequest(req).Destroy()
+		okHandler(w, req)
+	})
+
+	t.Run("ok, empty session is not stored", func(t *testing.T) {
+		resp, cookie := serve(t, whoami, "GET", "")
+		assert.Empty(t, cookie)
+		assert.NotContains(t, resp, "set-cookie")
+		assert.True(t, seen.IsNew())
+		assert.Empty(t, store.sessions)
+	})
+
+	t.Run("ok, login, load, rotate and logout", func(t *testing.T) {
+		resp, id := serve(t, login, "POST", "")
+		require.NotEmpty(t, id)
+		assert.Contains(t, resp, "set-cookie: session="+id+"; Path=/; Max-Age=86400; Secure; HttpOnly\r\n")
+		assert.Contains(t, resp, "cache-control: no-store\r\n")
+
+		_, cookie := serve(t, whoami, "GET", id)
+		assert.Empty(t, cookie, "unchanged session is not sent again")
+		assert.False(t, seen.IsNew())
+		user, _ := seen.Get("user")
+		assert.Equal(t, "admin", user)
+
+		_, renewed := serve(t, login, "POST", id)
+		require.NotEmpty(t, renewed)
+		assert.NotEqual(t, id, renewed)
+		_, err := store.Load(context.Background(), id)
+		assert.ErrorIs(t, err, ErrNotFound, "old ID is deleted")
+
+		resp, _ = serve(t, logout, "POST", renewed)
+		assert.Contains(t, resp, "set-cookie: session=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0\r\n")
+		_, err = store.Load(context.Background(), renewed)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("ok, set after destroy starts new session", func(t *testing.T) {
+		_, id := serve(t, login, "POST", "")
+
+		switchUser := mw(func(w response.Writer, req *request.Request) {
+			s := FromRequest(req)
+			s.Destroy()
+			s.Set("user", "guest")
+			okHandler(w, req)
+		})
+		_, fresh := serve(t, switchUser, "POST", id)
+		require.NotEmpty(t, fresh)
+		assert.NotEqual(t, id, fresh)
+
+		_, err := store.Load(context.Background(), id)
+		assert.ErrorIs(t, err, ErrNotFound)
+		values, err := store.Load(context.Background(), fresh)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"user": "guest"}, values)
+	})
+
+	t.Run("fail, unknown ID is not reused", func(t *testing.T) {
+		forged := strings.Repeat("ab", idSize)
+		_, id := serve(t, login, "POST", forged)
+		assert.NotEqual(t, forged, id)
+
+		serve(t, whoami, "GET", forged)
+		assert.True(t, seen.IsNew())
+		assert.NotEqual(t, forged, seen.ID())
+	})
+
+	t.Run("ok, changes after headers are stored", func(t *testing.T) {
+		late := mw(func(w response.Writer, req *request.Request) {
+			s := FromRequest(req)
+			s.Set("step", "1")
+			okHandler(w, req)
+			s.Set("step", "2")
+		})
+		_, id := serve(t, late, "GET", "")
+		values, err := store.Load(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "2", values["step"])
+	})
+
+	t.Run("fail, renew after headers", func(t *testing.T) {
+		_, id := serve(t, login, "POST", "")
+
+		var renewErr error
+		late := mw(func(w response.Writer, req *request.Request) {
+			okHandler(w, req)
+			renewErr = FromRequest(req).RenewID()
+		})
+		_, cookie := serve(t, late, "POST", id)
+		assert.ErrorIs(t, renewErr, ErrHeadersSent)
+		assert.Empty(t, cookie)
+
+		// client keeps a working session
+		values, err := store.Load(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "admin", values["user"])
+	})
+}
+
+func TestSignedCookie(t *testing.T) {
+	codec, err := securecookie.New(bytes.Repeat([]byte("k"), 32))
+	require.NoError(t, err)
+	store := NewMemoryStore()
+	mw := Middleware(Options{Store: store, Codec: codec})
+
+	var seen *Session
+	h := mw(func(w response.Writer, req *request.Request) {
+		seen = FromRequest(req)
+		seen.Set("visited", "yes")
+		okHandler(w, req)
+	})
+
+	_, signed := serve(t, h, "GET", "")
+	id, err := codec.Verify("session", signed)
+	require.NoError(t, err)
+	assert.Equal(t, seen.ID(), id)
+
+	serve(t, h, "GET", signed)
+	assert.Equal(t, id, seen.ID())
+
+	serve(t, h, "GET", id)
+	assert.NotEqual(t, id, seen.ID(), "unsigned ID is rejected")
+}
+
+func TestVerifyCSRF(t *testing.T) {
+	store := NewMemoryStore()
+	mw := Middleware(Options{Store: store})
+
+	var token string
+	form := mw(func(w response.Writer, req *request.Request) {
+		token = FromRequest(req).CSRFToken()
+		okHandler(w, req)
+	})
+	submit := server.Chain(mw, VerifyCSRF)(okHandler)
+
+	_, id := serve(t, form, "GET", "")
+	require.NotEmpty(t, token)
+
+	t.Run("ok, safe method", func(t *testing.T) {
+		resp, _ := serve(t, submit, "GET", "")
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK"))
+	})
+	t.Run("ok, token in header", func(t *testing.T) {
+		resp, _ := serve(t, submit, "DELETE", id, "X-CSRF-Token: "+token)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK"))
+	})
+	t.Run("ok, token in form", func(t *testing.T) {
+		body := "name=x&csrf_token=" + token
+		resp, _ := serveBody(t, submit, "POST", id, body, "Content-Type: application/x-www-form-urlencoded")
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK"))
+	})
+	t.Run("fail, missing or wrong token", func(t *testing.T) {
+		for _, extra := range [][]string{nil, {"X-CSRF-Token: " + strings.Repeat("0", len(token))}} {
+			resp, _ := serve(t, submit, "POST", id, extra...)
+			assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden"))
+		}
+	})
+	t.Run("fail, token of other session", func(t *testing.T) {
+		resp, _ := serve(t, submit, "POST", "", "X-CSRF-Token: "+token)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden"))
+	})
+}
@@ -0,0 +1,225 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+// Store keeps session values by ID. Expired sessions must not be loaded
+type Store interface {
+	// Load returns ErrNotFound for unknown or expired sessions
+	Load(ctx context.Context, id string) (map[string]string, error)
+	// Save replaces values of the session and sets it to expire after ttl
+	Save(ctx context.Context, id string, values map[string]string, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+type record struct {
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+func (r record) expired(now time.Time) bool {
+	return !now.Before(r.Expires)
+}
+
+// MemoryStore keeps sessions in memory, they are lost on restart
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]record
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]record{}, now: time.Now}
+}
+
+func (m *MemoryStore) Load(_ context.Context, id string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.sessions[id]
+	if !ok || r.expired(m.now()) {
+		return nil, ErrNotFound
+	}
+	return maps.Clone(r.Values), nil
+}
+
+func (m *MemoryStore) Save(_ context.Context, id string, values map[string]string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = record{Values: maps.Clone(values), Expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// Evict removes expired sessions every interval until ctx is done
+func (m *MemoryStore) Evict(ctx context.Context, interval time.Duration) {
+	evictEvery(ctx, interval, m.evictExpired)
+}
+
+func (m *MemoryStore) evictExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	maps.DeleteFunc(m.sessions, func(_ string, r record) bool {
+		return r.expired(now)
+	})
+}
+
+// FileStore keeps every session in a JSON file named by session ID, so
+// sessions survive restarts
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+// path returns file of the session, IDs not generated by this package
+// are rejected so they can't point outside of dir
+func (f *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileStore) Load(_ context.Context, id string) (map[string]string, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := readRecord(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.expired(f.now()) {
+		os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return r.Values, nil
+}
+
+func (f *FileStore) Save(_ context.Context, id string, values map[string]string, ttl time.Duration) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record{Values: values, Expires: f.now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	// rename makes the write atomic for concurrent loads
+	tmp, err := os.CreateTemp(f.dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileStore) Delete(_ context.Context, id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Evict removes files of expired sessions every interval until ctx is
+// done
+func (f *FileStore) Evict(ctx context.Context, interval time.Duration) {
+	evictEvery(ctx, interval, f.evictExpired)
+}
+
+func (f *FileStore) evictExpired() {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		slog.Error("failed to read sessions directory", "context_error", err)
+		return
+	}
+
+	now := f.now()
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+
+		path := filepath.Join(f.dir, e.Name())
+		if r, err := readRecord(path); err == nil && r.expired(now) {
+			os.Remove(path)
+		}
+	}
+}
+
+func readRecord(path string) (record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return record{}, err
+	}
+
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return record{}, err
+	}
+	return r, nil
+}
+
+func evictEvery(ctx context.Context, interval time.Duration, evict func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			evict()
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	ctx := context.Background()
+	id := newID()
+
+	_, err := store.Load(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.Save(ctx, id, map[string]string{"user": "admin"}, time.Hour))
+	values, err := store.Load(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user": "admin"}, values)
+
+	values["user"] = "changed"
+	values, err = store.Load(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", values["user"], "loaded values are a copy")
+
+	advance(2 * time.Hour)
+	_, err = store.Load(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound, "expired")
+
+	require.NoError(t, store.Save(ctx, id, map[string]string{}, time.Hour))
+	require.NoError(t, store.Delete(ctx, id))
+	_, err = store.Load(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, store.Delete(ctx, id))
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	t.Run("ok, load save delete", func(t *testing.T) {
+		testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+	})
+	t.Run("ok, evict expired", func(t *testing.T) {
+		ctx := context.Background()
+		require.NoError(t, store.Save(ctx, "short", nil, time.Minute))
+		require.NoError(t, store.Save(ctx, "long", nil, time.Hour))
+
+		now = now.Add(10 * time.Minute)
+		store.evictExpired()
+		assert.Len(t, store.sessions, 1)
+		assert.Contains(t, store.sessions, "long")
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	store, err := NewFileStore(filepath.Join(dir, "sessions"))
+	require.NoError(t, err)
+	store.now = func() time.Time { return now }
+
+	t.Run("ok, load save delete", func(t *testing.T) {
+		testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+	})
+	t.Run("ok, survives new store", func(t *testing.T) {
+		id := newID()
+		require.NoError(t, store.Save(context.Background(), id, map[string]string{"a": "1"}, time.Hour))
+
+		reopened, err := NewFileStore(filepath.Join(dir, "sessions"))
+		require.NoError(t, err)
+		values, err := reopened.Load(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "1", values["a"])
+	})
+	t.Run("ok, evict expired", func(t *testing.T) {
+		ctx := context.Background()
+		short, long := newID(), newID()
+		require.NoError(t, store.Save(ctx, short, nil, time.Minute))
+		require.NoError(t, store.Save(ctx, long, nil, 3*time.Hour))
+
+		now = now.Add(2 * time.Hour)
+		store.evictExpired()
+		assert.NoFileExists(t, filepath.Join(dir, "sessions", short+".json"))
+		assert.FileExists(t, filepath.Join(dir, "sessions", long+".json"))
+	})
+	t.Run("fail, invalid IDs", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.json"), []byte(`{"values":{}}`), 0o600))
+
+		for _, id := range []string{"../secret", "", "abc", "../" + newID()[3:]} {
+			_, err := store.Load(context.Background(), id)
+			assert.ErrorIs(t, err, ErrNotFound, id)
+			assert.Error(t, store.Save(context.Background(), id, nil, time.Hour), id)
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+)
+
+const (
+	algHMACSHA256     = "hmac-sha256"
+	componentDigest   = "content-digest"
+	componentSigParam = "@signature-params"
+)
+
+var defaultComponents = []string{"@method", "@authority", "@path"}
+
+// MessageSignature is HTTP Message Signatures scheme, RFC 9421, with
+// hmac-sha256 algorithm. Body is covered with Content-Digest, RFC 9530
+type MessageSignature struct {
+	// Keys are HMAC secrets by keyid
+	Keys map[string][]byte
+	// Label selects one of the signatures, any of them is accepted if
+	// empty
+	Label string
+	// Components have to be covered by signature, "@method", "@authority"
+	// and "@path" by default. content-digest is required if request has
+	// body
+	Components []string
+	// Tolerance is allowed difference with created, 5 minutes by default
+	Tolerance time.Duration
+}
+
+func (s *MessageSignature) Verify(req *request.Request, now time.Time) (Signed, error) {
+	inputs, okInputs := req.Headers.GetDictionary("Signature-Input")
+	signatures, okSignatures := req.Headers.GetDictionary("Signature")
+	if !okInputs || !okSignatures {
+		return Signed{}, ErrUnsigned
+	}
+
+	err := ErrUnsigned
+	for _, input := range inputs {
+		if s.Label != "" && input.Key != s.Label {
+			continue
+		}
+
+		var signed Signed
+		if signed, err = s.verifyInput(req, now, input, signatures); err == nil {
+			return signed, nil
+		}
+	}
+	return Signed{}, err
+}
+
+func (s *MessageSignature) verifyInput(req *request.Request, now time.Time, input headers.DictMember, signatures headers.Dictionary) (Signed, error) {
+	covered, ok := input.Member.(headers.InnerList)
+	if !ok {
+		return Signed{}, invalidSignature("signature input is not inner list")
+	}
+
+	member, ok := signatures.Get(input.Key)
+	if !ok {
+		return Signed{}, invalidSignature("no signature for label " + input.Key)
+	}
+	item, ok := member.(headers.Item)
+	signature, isBytes := item.Value.([]byte)
+	if !ok || !isBytes {
+		return Signed{}, invalidSignature("signature is not byte sequence")
+	}
+
+	key, err := s.key(covered.Params)
+	if err != nil {
+		return Signed{}, err
+	}
+
+	expires, err := s.checkTime(covered.Params, now)
+	if err != nil {
+		return Signed{}, err
+	}
+
+	names, err := s.coveredNames(req, covered)
+	if err != nil {
+		return Signed{}, err
+	}
+
+	base, err := signatureBase(req, names, covered)
+	if err != nil {
+		return Signed{}, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(base)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return Signed{}, ErrInvalidSignature
+	}
+
+	if slices.Contains(names, componentDigest) {
+		if err := verifyContentDigest(req); err != nil {
+			return Signed{}, err
+		}
+	}
+
+	// nonce is made to detect replays, RFC 9421 section 7.2.2. It is
+	// unique only for a signer, so it is scoped by keyid
+	id := "signature:" + base64.StdEncoding.EncodeToString(signature)
+	if nonce, ok := covered.Params.Get("nonce"); ok {
+		keyID, _ := covered.Params.Get("keyid")
+		id = fmt.Sprintf("nonce:%q:%v", keyID, nonce)
+	}
+	return Signed{ID: id, Expires: expires}, nil
+}
+
+func (s *MessageSignature) key(params headers.Params) ([]byte, error) {
+	if alg, ok := params.Get("alg"); ok && alg != algHMACSHA256 {
+		return nil, invalidSignature(fmt.Sprintf("unsupported algorithm %v", alg))
+	}
+
+	keyID, ok := params.Get("keyid")
+	id, isString := keyID.(string)
+	if !ok || !isString {
+		return nil, invalidSignature("no keyid")
+	}
+
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, invalidSignature("unknown keyid " + id)
+	}
+	return key, nil
+}
+
+// checkTime checks created and expires parameters, created is required
+// so replays can be detected in limited time
+func (s *MessageSignature) checkTime(params headers.Params, now time.Time) (time.Time, error) {
+	tolerance := s.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultTolerance
+	}
+
+	created, ok := params.Get("created")
+	seconds, isInt := created.(int64)
+	if !ok || !isInt {
+		return time.Time{}, invalidSignature("no created parameter")
+	}
+
+	createdAt := time.Unix(seconds, 0)
+	if createdAt.Before(now.Add(-tolerance)) || createdAt.After(now.Add(tolerance)) {
+		return time.Time{}, ErrExpired
+	}
+
+	if expires, ok := params.Get("expires"); ok {
+		seconds, isInt := expires.(int64)
+		if !isInt || !now.Before(time.Unix(seconds, 0)) {
+			return time.Time{}, ErrExpired
+		}
+	}
+
+	return createdAt.Add(tolerance), nil
+}
+
+// coveredNames returns component names and checks required ones are
+// covered
+func (s *MessageSignature) coveredNames(req *request.Request, covered headers.InnerList) ([]string, error) {
+	names := []string{}
+	for _, item := range covered.Items {
+		name, ok := item.Value.(string)
+		if !ok {
+			return nil, invalidSignature("component name is not string")
+		}
+		// sf, key, bs, req and name parameters are not supported
+		if len(item.Params) > 0 {
+			return nil, invalidSignature("unsupported parameters of component " + name)
+		}
+		if slices.Contains(names, name) {
+			return nil, invalidSignature("duplicated component " + name)
+		}
+		names = append(names, name)
+	}
+
+	required := s.Components
+	if required == nil {
+		required = defaultComponents
+	}
+	if len(req.Body) > 0 {
+		required = append(slices.Clip(required), componentDigest)
+	}
+
+	for _, name := range required {
+		if !slices.Contains(names, name) {
+			return nil, invalidSignature("component " + name + " is not covered")
+		}
+	}
+	return names, nil
+}
+
+// signatureBase creates signed content, RFC 9421 section 2.5
+func signatureBase(req *request.Request, names []string, covered headers.InnerList) ([]byte, error) {
+	b := bytes.Buffer{}
+
+	for _, name := range names {
+		value, err := componentValue(req, name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%q: %s\n", name, value)
+	}
+
+	params, err := headers.SerializeList(headers.StructuredList{covered})
+	if err != nil {
+		return nil, invalidSignature("malformed signature input")
+	}
+	fmt.Fprintf(&b, "%q: %s", componentSigParam, params)
+
+	return b.Bytes(), nil
+}
+
+// componentValue returns derived component, RFC 9421 section 2.2, or
+// value of the field
+func componentValue(req *request.Request, name string) (string, error) {
+	target := req.RequestLine.RequestTarget
+	path, query, hasQuery := strings.Cut(target, "?")
+	authority, _ := req.Headers.GetString("Host")
+	authority = strings.ToLower(authority)
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	switch name {
+	case "@method":
+		return req.RequestLine.Method, nil
+	case "@target-uri":
+		return scheme + "://" + authority + target, nil
+	case "@authority":
+		return authority, nil
+	case "@scheme":
+		return scheme, nil
+	case "@request-target":
+		return target, nil
+	case "@path":
+		return path, nil
+	case "@query":
+		if !hasQuery {
+			return "?", nil
+		}
+		return "?" + query, nil
+	}
+
+	if strings.HasPrefix(name, "@") {
+		return "", invalidSignature("unsupported component " + name)
+	}
+	if name != strings.ToLower(name) {
+		return "", invalidSignature("component name " + name + " is not lowercase")
+	}
+
+	value, ok := req.Headers.GetString(name)
+	if !ok {
+		return "", invalidSignature("no field " + name)
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// verifyContentDigest checks digests of body with supported algorithms,
+// RFC 9530. At least one of them has to be present
+func verifyContentDigest(req *request.Request) error {
+	digests, ok := req.Headers.GetDictionary("Content-Digest")
+	if !ok {
+		return invalidSignature("malformed Content-Digest")
+	}
+
+	sha256Sum := sha256.Sum256(req.Body)
+	sha512Sum := sha512.Sum512(req.Body)
+	sums := map[string][]byte{"sha-256": sha256Sum[:], "sha-512": sha512Sum[:]}
+
+	verified := false
+	for _, digest := range digests {
+		sum, ok := sums[digest.Key]
+		if !ok {
+			continue
+		}
+
+		item, _ := digest.Member.(headers.Item)
+		value, _ := item.Value.([]byte)
+		if !hmac.Equal(value, sum) {
+			return invalidSignature("body doesn't match Content-Digest")
+		}
+		verified = true
+	}
+
+	if !verified {
+		return invalidSignature("no supported Content-Digest algorithm")
+	}
+	return nil
+}
+
+func invalidSignature(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSignature, reason)
+}
@@ -0,0 +1,253 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/headers"
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+)
+
+const defaultTolerance = 5 * time.Minute
+
+var (
+	ErrUnsigned         = errors.New("request is not signed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("signature is expired")
+	ErrReplayed         = errors.New("request is replayed")
+)
+
+// Signed identifies verified request for replay detection
+type Signed struct {
+	// ID is the same for a replayed request, e.g. signature or nonce
+	ID string
+	// Expires is time after which the request is rejected by timestamp
+	// check, so it doesn't have to be remembered longer
+	Expires time.Time
+}
+
+// Scheme verifies signature of request body and headers
+type Scheme interface {
+	Verify(req *request.Request, now time.Time) (Signed, error)
+}
+
+// Middleware answers 403 to requests without valid signature of scheme or
+// with signature already seen by replays, handler isn't called for them.
+// Replays aren't detected if replays is nil
+func Middleware(scheme Scheme, replays *ReplayCache) server.Middleware {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(w response.Writer, req *request.Request) {
+			now := time.Now()
+			if replays != nil {
+				now = replays.now()
+			}
+
+			signed, err := scheme.Verify(req, now)
+			if err == nil && replays != nil && !replays.Remember(signed) {
+				err = ErrReplayed
+			}
+
+			if err != nil {
+				// reason is logged only, it helps to forge a signature
+				slog.Info("rejected webhook", "target", req.RequestLine.RequestTarget, "context_error", err)
+				server.RenderError(w, req, server.NewHandlerError(response.StatusForbidden, ""))
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+// ReplayCache remembers verified requests until they expire
+type ReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{seen: map[string]time.Time{}, now: time.Now}
+}
+
+// Remember returns false if request with the same ID was seen before
+func (c *ReplayCache) Remember(s Signed) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if expires, ok := c.seen[s.ID]; ok && c.now().Before(expires) {
+		return false
+	}
+	c.seen[s.ID] = s.Expires
+	return true
+}
+
+// Evict forgets expired requests every interval until ctx is done
+func (c *ReplayCache) Evict(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.evictExpired()
+		}
+	}
+}
+
+func (c *ReplayCache) evictExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	maps.DeleteFunc(c.seen, func(_ string, expires time.Time) bool {
+		return !now.Before(expires)
+	})
+}
+
+type Encoding int
+
+const (
+	Hex Encoding = iota
+	Base64
+)
+
+// HMAC is signature scheme of most webhook vendors: HMAC of a string
+// made of body and optionally timestamp, sent in a header
+type HMAC struct {
+	// Header carries signature, it may have a comma separated list of
+	// them, e.g. while vendor rotates secrets
+	Header string
+	// Prefix is stripped from signature, e.g. "sha256="
+	Prefix string
+	// Hash is sha256.New by default
+	Hash     func() hash.Hash
+	Encoding Encoding
+	// Secrets are tried in order, so a secret can be rotated
+	Secrets [][]byte
+
+	// TimestampHeader carries Unix time of signing. Without it replays
+	// are detected only within Tolerance
+	TimestampHeader string
+	// Tolerance is allowed difference with timestamp, 5 minutes by default
+	Tolerance time.Duration
+	// Layout of the signed string with {body}, {timestamp}, {method} and
+	// {path} placeholders, "{body}" by default
+	Layout string
+}
+
+// GitHub verifies X-Hub-Signature-256 of GitHub webhooks
+func GitHub(secrets ...[]byte) *HMAC {
+	return &HMAC{Header: "X-Hub-Signature-256", Prefix: "sha256=", Secrets: secrets}
+}
+
+// Slack verifies X-Slack-Signature of Slack requests
+func Slack(secrets ...[]byte) *HMAC {
+	return &HMAC{
+		Header:          "X-Slack-Signature",
+		Prefix:          "v0=",
+		Secrets:         secrets,
+		TimestampHeader: "X-Slack-Request-Timestamp",
+		Layout:          "v0:{timestamp}:{body}",
+	}
+}
+
+func (s *HMAC) Verify(req *request.Request, now time.Time) (Signed, error) {
+	value, ok := req.Headers.GetString(s.Header)
+	if !ok {
+		return Signed{}, ErrUnsigned
+	}
+
+	tolerance := s.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultTolerance
+	}
+	expires := now.Add(tolerance)
+
+	timestamp := ""
+	if s.TimestampHeader != "" {
+		if timestamp, ok = req.Headers.GetString(s.TimestampHeader); !ok {
+			return Signed{}, ErrUnsigned
+		}
+
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return Signed{}, invalidSignature("malformed timestamp")
+		}
+		signedAt := time.Unix(seconds, 0)
+		if signedAt.Before(now.Add(-tolerance)) || signedAt.After(now.Add(tolerance)) {
+			return Signed{}, ErrExpired
+		}
+		expires = signedAt.Add(tolerance)
+	}
+
+	message := []byte(s.expandLayout(req, timestamp))
+
+	for _, encoded := range headers.SplitList(value) {
+		encoded, ok := strings.CutPrefix(encoded, s.Prefix)
+		if !ok {
+			continue
+		}
+		signature, err := s.decode(encoded)
+		if err != nil {
+			continue
+		}
+
+		for _, secret := range s.Secrets {
+			if hmac.Equal(signature, s.sum(secret, message)) {
+				return Signed{ID: s.Header + ":" + hex.EncodeToString(signature), Expires: expires}, nil
+			}
+		}
+	}
+
+	return Signed{}, ErrInvalidSignature
+}
+
+func (s *HMAC) expandLayout(req *request.Request, timestamp string) string {
+	layout := s.Layout
+	if layout == "" {
+		layout = "{body}"
+	}
+
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	// replaced values aren't scanned for placeholders again
+	return strings.NewReplacer(
+		"{body}", string(req.Body),
+		"{timestamp}", timestamp,
+		"{method}", req.RequestLine.Method,
+		"{path}", path,
+	).Replace(layout)
+}
+
+func (s *HMAC) sum(secret, message []byte) []byte {
+	newHash := s.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+
+	mac := hmac.New(newHash, secret)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (s *HMAC) decode(encoded string) ([]byte, error) {
+	if s.Encoding == Base64 {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	return hex.DecodeString(strings.ToLower(encoded))
+}
@@ -0,0 +1,264 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SSL0/http-impl/internal/request"
+	"github.com/SSL0/http-impl/internal/response"
+	"github.com/SSL0/http-impl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("webhook secret")
+
+func newRequest(t *testing.T, target, body string, extra ...string) *request.Request {
+	t.Helper()
+	raw := "POST " + target + " HTTP/1.1\r\nHost: Example.com\r\n"
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func serve(h server.HandlerFunc, req *request.Request) string {
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func okHandler(w response.Writer, req *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func hmacHex(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestMiddleware(t *testing.T) {
+	replays := NewReplayCache()
+	h := Middleware(GitHub([]byte("old secret"), secret), replays)(okHandler)
+	body := `{"action":"opened"}`
+
+	t.Run("ok, signed", func(t *testing.T) {
+		req := newRequest(t, "/hooks/github", body, "X-Hub-Signature-256: sha256="+hmacHex(secret, body))
+		assert.Contains(t, serve(h, req), "HTTP/1.1 200 OK")
+	})
+
+	t.Run("fail, replayed", func(t *testing.T) {
+		req := newRequest(t, "/hooks/github", body, "X-Hub-Signature-256: sha256="+hmacHex(secret, body))
+		resp := serve(h, req)
+		assert.Contains(t, resp, "HTTP/1.1 403 Forbidden")
+		assert.NotContains(t, resp, "replayed")
+	})
+
+	t.Run("ok, accepted again after expiry", func(t *testing.T) {
+		now := time.Now().Add(defaultTolerance)
+		replays.now = func() time.Time { return now }
+		defer func() { replays.now = time.Now }()
+
+		replays.evictExpired()
+		assert.Empty(t, replays.seen)
+
+		req := newRequest(t, "/hooks/github", body, "X-Hub-Signature-256: sha256="+hmacHex(secret, body))
+		assert.Contains(t, serve(h, req), "HTTP/1.1 200 OK")
+	})
+
+	t.Run("fail, unsigned", func(t *testing.T) {
+		resp := serve(h, newRequest(t, "/hooks/github", body))
+		assert.Contains(t, resp, "HTTP/1.1 403 Forbidden")
+		assert.NotContains(t, resp, "signed")
+	})
+
+	t.Run("fail, body changed", func(t *testing.T) {
+		req := newRequest(t, "/hooks/github", `{"action":"closed"}`, "X-Hub-Signature-256: sha256="+hmacHex(secret, body))
+		resp := serve(h, req)
+		assert.Contains(t, resp, "HTTP/1.1 403 Forbidden")
+		assert.NotContains(t, resp, "signature")
+	})
+}
+
+func TestMiddlewareWithoutReplayCache(t *testing.T) {
+	h := Middleware(GitHub(secret), nil)(okHandler)
+	body := `{"action":"opened"}`
+
+	for range 2 {
+		req := newRequest(t, "/hooks/github", body, "X-Hub-Signature-256: sha256="+hmacHex(secret, body))
+		assert.Contains(t, serve(h, req), "HTTP/1.1 200 OK")
+	}
+	assert.Contains(t, serve(h, newRequest(t, "/hooks/github", body)), "HTTP/1.1 403 Forbidden")
+}
+
+func TestHMAC(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := "token=abc&command=/deploy"
+
+	t.Run("ok, timestamp in layout", func(t *testing.T) {
+		ts := strconv.FormatInt(now.Unix()-60, 10)
+		req := newRequest(t, "/slack", body,
+			"X-Slack-Request-Timestamp: "+ts,
+			"X-Slack-Signature: v0="+hmacHex(secret, "v0:"+ts+":"+body))
+
+		signed, err := Slack(secret).Verify(req, now)
+		require.NoError(t, err)
+		assert.Equal(t, now.Add(defaultTolerance-time.Minute), signed.Expires)
+	})
+
+	t.Run("fail, timestamp out of tolerance", func(t *testing.T) {
+		ts := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+		req := newRequest(t, "/slack", body,
+			"X-Slack-Request-Timestamp: "+ts,
+			"X-Slack-Signature: v0="+hmacHex(secret, "v0:"+ts+":"+body))
+
+		_, err := Slack(secret).Verify(req, now)
+		assert.ErrorIs(t, err, ErrExpired)
+	})
+
+	t.Run("fail, no timestamp", func(t *testing.T) {
+		req := newRequest(t, "/slack", body, "X-Slack-Signature: v0="+hmacHex(secret, "v0::"+body))
+		_, err := Slack(secret).Verify(req, now)
+		assert.ErrorIs(t, err, ErrUnsigned)
+	})
+
+	t.Run("ok, custom scheme with list of signatures", func(t *testing.T) {
+		scheme := &HMAC{
+			Header:   "X-Signature",
+			Prefix:   "v1=",
+			Hash:     sha1.New,
+			Encoding: Base64,
+			Secrets:  [][]byte{secret},
+			Layout:   "{method}\n{path}\n{body}",
+		}
+
+		mac := hmac.New(sha1.New, secret)
+		mac.Write([]byte("POST\n/hooks/custom\n" + body))
+		signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+		req := newRequest(t, "/hooks/custom?x=1", body, "X-Signature: v0=abc, v1=bm9wZQ==, v1="+signature)
+		_, err := scheme.Verify(req, now)
+		assert.NoError(t, err)
+	})
+}
+
+// signRFC9421 signs signature base built by hand, so it checks
+// serialization of the base
+func signRFC9421(key []byte, base string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(base))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestMessageSignature(t *testing.T) {
+	key := []byte("test-shared-secret")
+	scheme := &MessageSignature{Keys: map[string][]byte{"test-key": key}}
+
+	body := `{"hello": "world"}`
+	digest := "Content-Digest: sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+	created := int64(1618884473)
+	now := time.Unix(created+10, 0)
+
+	params := `("@method" "@authority" "@path" "@query" "content-type" "content-digest");created=1618884473;keyid="test-key";nonce="abc"`
+	base := `"@method": POST` + "\n" +
+		`"@authority": example.com` + "\n" +
+		`"@path": /foo` + "\n" +
+		`"@query": ?param=Value&Pet=dog` + "\n" +
+		`"content-type": application/json` + "\n" +
+		`"content-digest": sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:` + "\n" +
+		`"@signature-params": ` + params
+	signed := func(body string, extra ...string) *request.Request {
+		lines := append([]string{
+			"Content-Type:  application/json ",
+			digest,
+			"Signature-Input: sig1=" + params,
+			"Signature: sig1=:" + signRFC9421(key, base) + ":",
+		}, extra...)
+		return newRequest(t, "/foo?param=Value&Pet=dog", body, lines...)
+	}
+
+	t.Run("ok, signed", func(t *testing.T) {
+		s, err := scheme.Verify(signed(body), now)
+		require.NoError(t, err)
+		assert.Equal(t, `nonce:"test-key":abc`, s.ID)
+		assert.Equal(t, time.Unix(created, 0).Add(defaultTolerance), s.Expires)
+	})
+
+	t.Run("ok, nonce is scoped by key", func(t *testing.T) {
+		otherKey := []byte("other-shared-secret")
+		both := &MessageSignature{Keys: map[string][]byte{"test-key": key, "other-key": otherKey}}
+		otherParams := strings.Replace(params, `keyid="test-key"`, `keyid="other-key"`, 1)
+		otherBase := strings.Replace(base, params, otherParams, 1)
+		req := newRequest(t, "/foo?param=Value&Pet=dog", body,
+			"Content-Type:  application/json ",
+			digest,
+			"Signature-Input: sig1="+otherParams,
+			"Signature: sig1=:"+signRFC9421(otherKey, otherBase)+":")
+
+		other, err := both.Verify(req, now)
+		require.NoError(t, err)
+		s, err := both.Verify(signed(body), now)
+		require.NoError(t, err)
+		assert.NotEqual(t, s.ID, other.ID)
+	})
+
+	t.Run("ok, selected label", func(t *testing.T) {
+		labeled := &MessageSignature{Keys: scheme.Keys, Label: "sig1"}
+		_, err := labeled.Verify(signed(body), now)
+		assert.NoError(t, err)
+	})
+
+	t.Run("fail, body doesn't match digest", func(t *testing.T) {
+		_, err := scheme.Verify(signed(`{"hello": "there"}`), now)
+		assert.ErrorContains(t, err, "body doesn't match Content-Digest")
+	})
+
+	t.Run("fail, expired", func(t *testing.T) {
+		_, err := scheme.Verify(signed(body), now.Add(time.Hour))
+		assert.ErrorIs(t, err, ErrExpired)
+	})
+
+	t.Run("fail, unknown key", func(t *testing.T) {
+		other := &MessageSignature{Keys: map[string][]byte{"other": key}}
+		_, err := other.Verify(signed(body), now)
+		assert.ErrorContains(t, err, "unknown keyid test-key")
+	})
+
+	t.Run("fail, required component is not covered", func(t *testing.T) {
+		strict := &MessageSignature{Keys: scheme.Keys, Components: []string{"@method", "@target-uri"}}
+		_, err := strict.Verify(signed(body), now)
+		assert.ErrorContains(t, err, "component @target-uri is not covered")
+	})
+
+	t.Run("fail, body is not covered", func(t *testing.T) {
+		params := `("@method" "@authority" "@path");created=1618884473;keyid="test-key"`
+		base := "\"@method\": POST\n\"@authority\": example.com\n\"@path\": /foo\n\"@signature-params\": " + params
+		req := newRequest(t, "/foo", body,
+			"Signature-Input: sig1="+params,
+			"Signature: sig1=:"+signRFC9421(key, base)+":")
+
+		_, err := scheme.Verify(req, now)
+		assert.ErrorContains(t, err, "component content-digest is not covered")
+	})
+
+	t.Run("fail, unsigned", func(t *testing.T) {
+		_, err := scheme.Verify(newRequest(t, "/foo", ""), now)
+		assert.ErrorIs(t, err, ErrUnsigned)
+	})
+}